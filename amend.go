@@ -0,0 +1,147 @@
+package orderbook
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// AmendOrder atomically changes the price and/or the quantity of a
+// resting limit order, or a triggered stop-limit one, keeping its ID.  A
+// zero price or quantity keeps the current one.  The quantity is the new
+// total one, including what has been executed so far.
+//
+// Decreasing the quantity keeps the order's place in the queue.
+// Changing the price or increasing the quantity sends the order to the
+// back of the queue and may match it right away, just like a new order.
+func (b *Book) AmendOrder(id string, price, quantity decimal.Decimal) (Report, error) {
+	if id == "" {
+		return Report{}, ErrInvalidID
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	defer b.emitLevels(now)
+
+	// Orders past their expire time cannot be amended anymore.
+	b.expireOrders(now)
+
+	order, ok := b.lookup(id)
+	if !ok {
+		return Report{}, ErrOrderDoesNotExist
+	}
+
+	switch {
+	case order.State == StateCanceled:
+		return Report{}, ErrOrderAlreadyCanceled
+	case order.State == StateFilled:
+		return Report{}, ErrOrderAlreadyFilled
+	case order.Type != TypeLimit && order.Type != TypeStopLimit,
+		order.State != StatePlaced && order.State != StatePartiallyFilled:
+		// Stop-limit orders rest in the book once triggered.
+		return Report{}, ErrCannotAmendOrder
+	}
+
+	amended := order
+
+	if !price.IsZero() {
+		amended.Price = price
+	}
+
+	if !quantity.IsZero() {
+		amended.OriginalQuantity = quantity
+	}
+
+	if err := b.checkAmend(&amended); err != nil {
+		return Report{}, err
+	}
+
+	amended.LeavesQuantity = amended.OriginalQuantity.Sub(amended.ExecutedQuantity).Sub(amended.PreventedQuantity)
+
+	ladder, current, err := b.ladderOf(order)
+	if err != nil {
+		return Report{}, err
+	}
+
+	// More quantity must still fit, see checkCapacity.
+	if !ladder.Fits(amended.units.leaves() - order.units.leaves()) {
+		return Report{}, ErrLadderOverflow
+	}
+
+	b.emitOrder(EventOrderAmended, amended, now)
+	b.touch(ladder, current)
+
+	// Same price and no more quantity: the order keeps its priority.
+	if amended.Price.Equal(order.Price) && amended.OriginalQuantity.LessThanOrEqual(order.OriginalQuantity) {
+		reduction := order.units.quantity - amended.units.quantity
+		if reduction > 0 && !ladder.ReduceOrder(current, order.Handle, reduction) {
+			panic("illegal state")
+		}
+
+		b.store(amended, nil, now)
+
+		b.databaseMutex.Lock()
+		amended = b.database[order.Handle]
+		b.databaseMutex.Unlock()
+
+		return newReport(amended, []Trade{}, []PreventedMatch{}), nil
+	}
+
+	if !ladder.RemoveOrder(current, order.Handle) {
+		panic("illegal state")
+	}
+
+	trades, prevented := b.match(&amended, now)
+
+	// Just like new orders, amended ones may trigger stop orders.
+	b.trigger(now)
+
+	b.databaseMutex.Lock()
+	amended = b.database[order.Handle]
+	b.databaseMutex.Unlock()
+
+	return newReport(amended, trades, prevented), nil
+}
+
+// checkAmend validates the new price and quantity of an amended order.
+// The caller must hold b.mu.
+func (b *Book) checkAmend(order *ClientOrder) error {
+	// The new quantity must leave something to execute.
+	if order.OriginalQuantity.LessThanOrEqual(order.ExecutedQuantity.Add(order.PreventedQuantity)) {
+		return ErrInvalidQuantity
+	}
+
+	lots, err := b.Instrument.checkQuantity(order.OriginalQuantity)
+	if err != nil {
+		return err
+	}
+
+	order.units.quantity = lots
+
+	if order.Price.IsNegative() {
+		return ErrInvalidPrice
+	}
+
+	ticks, err := b.Instrument.checkPrice(order.Price)
+	if err != nil {
+		return err
+	}
+
+	order.units.price = ticks
+
+	if err := b.checkRange(*order); err != nil {
+		return err
+	}
+
+	if err := b.Instrument.CheckNotional(order.Price, order.OriginalQuantity); err != nil {
+		return err
+	}
+
+	if err := b.checkDisplayQuantity(order); err != nil {
+		return err
+	}
+
+	b.normalize(order)
+
+	return b.checkPostOnly(order, nil)
+}
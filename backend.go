@@ -0,0 +1,109 @@
+package orderbook
+
+import (
+	"container/heap"
+)
+
+// LadderBackend keeps the levels of a ladder ordered by price, best one
+// first.  It only stores levels, Ladder does the matching.  Prices are
+// in ticks.
+type LadderBackend interface {
+	// Len returns the number of levels.
+	Len() int
+
+	// Best returns the best level, or nil if there are none.
+	Best() *Level
+
+	// Get returns the level of the given price.
+	Get(price int64) (*Level, bool)
+
+	// Accepts reports whether levels of the given price can be stored.
+	Accepts(price int64) bool
+
+	// Insert adds a new level.  The backend must accept its price and
+	// must not have a level of the same price yet.
+	Insert(level *Level)
+
+	// Remove removes the given level.
+	Remove(level *Level)
+
+	// Walk calls f for each level, best one first, until f returns
+	// false.
+	Walk(f func(level *Level) bool)
+}
+
+// LadderBackendFactory creates empty backends for ladders of the given
+// type, Ask or Bid.
+type LadderBackendFactory func(ladderType int) LadderBackend
+
+// WithLadderBackend makes the book keep its bids and asks in backends of
+// the given factory.  Stop orders are kept in heap backends regardless,
+// as their stop prices are not limited to the range of the book.
+func WithLadderBackend(factory LadderBackendFactory) BookOption {
+	return func(b *Book) {
+		b.Asks = NewLadderWith(Ask, factory)
+		b.Bids = NewLadderWith(Bid, factory)
+	}
+}
+
+// +--------------+
+// | Heap backend |
+// +--------------+
+
+// heapBackend pairs a LevelHeap with a LevelMap.  Insertion and removal
+// take O(logN), the best level is at the top of the heap and in-order
+// traversal goes through Walk.
+type heapBackend struct {
+	heap    LevelHeap
+	mapping LevelMap
+}
+
+// NewHeapBackend creates a backend that keeps the levels in a heap.  It
+// accepts any price.
+func NewHeapBackend(_ int) LadderBackend {
+	const heapSize = 256
+
+	return &heapBackend{
+		heap:    NewLevelHeap(heapSize),
+		mapping: make(LevelMap),
+	}
+}
+
+func (h *heapBackend) Len() int {
+	return h.heap.Len()
+}
+
+func (h *heapBackend) Best() *Level {
+	if h.heap.Len() == 0 {
+		return nil
+	}
+
+	return h.heap[0]
+}
+
+func (h *heapBackend) Get(price int64) (*Level, bool) {
+	level, ok := h.mapping[price]
+
+	return level, ok
+}
+
+func (h *heapBackend) Accepts(_ int64) bool {
+	return true
+}
+
+func (h *heapBackend) Insert(level *Level) {
+	h.mapping[level.Price] = level
+	heap.Push(&h.heap, level)
+}
+
+func (h *heapBackend) Remove(level *Level) {
+	delete(h.mapping, level.Price)
+
+	if heap.Remove(&h.heap, level.index) == nil {
+		panic("illegal state")
+	}
+}
+
+func (h *heapBackend) Walk(f func(level *Level) bool) {
+	h.heap.Walk(f)
+}
@@ -0,0 +1,278 @@
+package orderbook_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+type backend struct {
+	name    string
+	factory orderbook.LadderBackendFactory
+}
+
+func backends() []backend {
+	return []backend{
+		{"heap", orderbook.NewHeapBackend},
+		{"skiplist", orderbook.NewSkipListBackend},
+		{"tick", orderbook.TickBackend(decimal.NewFromInt(1), decimal.NewFromInt(20000), decimal.NewFromInt(1))},
+	}
+}
+
+// tickInstrument has whole number prices, so the tick backend may span
+// them.
+func tickInstrument() orderbook.Instrument {
+	instrument := orderbook.DefaultInstrument()
+	instrument.TickSize = decimal.NewFromInt(1)
+	instrument.PricePrecision = 0
+
+	return instrument
+}
+
+func prices(ladder *orderbook.Ladder) []int64 {
+	ans := make([]int64, 0, ladder.Len())
+
+	ladder.Walk(func(level *orderbook.Level) bool {
+		ans = append(ans, level.Price)
+
+		return true
+	})
+
+	return ans
+}
+
+// All backends keep the levels in the same order as the heap one.
+func TestLadderBackend(t *testing.T) {
+	t.Parallel()
+
+	for _, x := range backends()[1:] {
+		for _, ladderType := range []int{orderbook.Ask, orderbook.Bid} {
+			random := rand.New(rand.NewSource(1)) //nolint:gosec
+			want := orderbook.NewLadder(ladderType)
+			have := orderbook.NewLadderWith(ladderType, x.factory)
+			handles := make(map[uint64]int64)
+
+			for i := 0; i < 2000; i++ {
+				handle := uint64(1 + random.Intn(300))
+
+				if price, ok := handles[handle]; ok {
+					want.RemoveOrder(price, handle)
+					have.RemoveOrder(price, handle)
+					delete(handles, handle)
+				} else {
+					price := int64(1 + random.Intn(100))
+					want.AddOrder(price, orderbook.NewOrder(handle, 1))
+					have.AddOrder(price, orderbook.NewOrder(handle, 1))
+					handles[handle] = price
+				}
+
+				// Every now and then sweep the best levels.
+				if i%100 == 99 {
+					want.MatchOrderMarket(orderbook.NewOrder(0, 20))
+					have.MatchOrderMarket(orderbook.NewOrder(0, 20))
+
+					for handle, price := range handles {
+						if _, ok := want.GetOrder(price, handle); !ok {
+							delete(handles, handle)
+						}
+					}
+				}
+
+				if w, h := fmt.Sprint(prices(&want)), fmt.Sprint(prices(&have)); w != h {
+					t.Fatalf("%s: have %s, want %s", x.name, h, w)
+				}
+
+				if want.Len() != have.Len() || (want.Len() > 0 && want.Best().Price != have.Best().Price) {
+					t.Fatalf("%s: have %d levels, want %d", x.name, have.Len(), want.Len())
+				}
+			}
+		}
+	}
+}
+
+// Orders priced out of the range of a tick backend get rejected.
+func TestBook_AddOrder_TickBackend(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook(orderbook.WithInstrument(tickInstrument()), orderbook.WithLadderBackend(
+		orderbook.TickBackend(decimal.NewFromInt(90), decimal.NewFromInt(110), decimal.NewFromInt(1))))
+
+	submit(t, b, limitOrder("sell110", orderbook.SideSell, 110, 1))
+	submit(t, b, limitOrder("buy90", orderbook.SideBuy, 90, 1))
+
+	for _, order := range []orderbook.ClientOrder{
+		limitOrder("sell111", orderbook.SideSell, 111, 1),
+		limitOrder("buy89", orderbook.SideBuy, 89, 1),
+	} {
+		if _, err := b.AddOrder(order); !errors.Is(err, orderbook.ErrPriceOutOfRange) {
+			t.Errorf("have %v, want ErrPriceOutOfRange", err)
+		}
+	}
+
+	if _, err := b.AmendOrder("sell110", decimal.NewFromInt(120), decimal.Zero); !errors.Is(err,
+		orderbook.ErrPriceOutOfRange) {
+		t.Errorf("have %v, want ErrPriceOutOfRange", err)
+	}
+
+	assertCountLevels(t, b, 1, 1)
+}
+
+// A tick backend with a different tick size than the instrument would
+// misplace the levels.
+func TestNewBook_TickBackendMismatch(t *testing.T) {
+	t.Parallel()
+
+	defer func() {
+		if recover() == nil {
+			t.Error("have no panic, want one")
+		}
+	}()
+
+	orderbook.NewBook(orderbook.WithInstrument(tickInstrument()), orderbook.WithLadderBackend(
+		orderbook.TickBackend(decimal.NewFromInt(90), decimal.NewFromInt(110), decimal.RequireFromString("0.5"))))
+}
+
+const benchmarkLevels = 1000
+
+func benchmarkPrice(i int) int64 {
+	return int64(10000 + i%benchmarkLevels)
+}
+
+func BenchmarkLadder_AddOrder(b *testing.B) {
+	for _, x := range backends() {
+		b.Run(x.name, func(b *testing.B) {
+			ladder := orderbook.NewLadderWith(orderbook.Ask, x.factory)
+			random := rand.New(rand.NewSource(1)) //nolint:gosec
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				ladder.AddOrder(benchmarkPrice(random.Int()), orderbook.NewOrder(uint64(i), 1))
+			}
+		})
+	}
+}
+
+func BenchmarkLadder_RemoveOrder(b *testing.B) {
+	for _, x := range backends() {
+		b.Run(x.name, func(b *testing.B) {
+			ladder := orderbook.NewLadderWith(orderbook.Ask, x.factory)
+			random := rand.New(rand.NewSource(1)) //nolint:gosec
+			orders := make([]int64, b.N)
+
+			for i := range orders {
+				orders[i] = benchmarkPrice(random.Int())
+				ladder.AddOrder(orders[i], orderbook.NewOrder(uint64(i), 1))
+			}
+
+			b.ResetTimer()
+
+			for i, price := range orders {
+				ladder.RemoveOrder(price, uint64(i))
+			}
+		})
+	}
+}
+
+// Each iteration adds an order at the best price and takes it out again.
+func BenchmarkLadder_MatchOrderMarket(b *testing.B) {
+	for _, x := range backends() {
+		b.Run(x.name, func(b *testing.B) {
+			ladder := orderbook.NewLadderWith(orderbook.Ask, x.factory)
+
+			// The best price is left for the order of each iteration.
+			for i := 1; i < benchmarkLevels; i++ {
+				ladder.AddOrder(benchmarkPrice(i), orderbook.NewOrder(uint64(i), 1))
+			}
+
+			price := benchmarkPrice(0)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if !ladder.AddOrder(price, orderbook.NewOrder(benchmarkLevels, 1)) {
+					b.Fatal("order not added")
+				}
+
+				ladder.MatchOrderMarket(orderbook.NewOrder(0, 1))
+			}
+		})
+	}
+}
+
+// Each iteration fills the best 10 levels up again and sweeps them with
+// a limit order, which stops short of the rest.
+func BenchmarkLadder_MatchOrderLimit(b *testing.B) {
+	const sweep = 10
+
+	for _, x := range backends() {
+		b.Run(x.name, func(b *testing.B) {
+			ladder := orderbook.NewLadderWith(orderbook.Ask, x.factory)
+
+			for i := sweep; i < benchmarkLevels; i++ {
+				ladder.AddOrder(benchmarkPrice(i), orderbook.NewOrder(uint64(i), 1))
+			}
+
+			limit := benchmarkPrice(sweep - 1)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < sweep; j++ {
+					ladder.AddOrder(benchmarkPrice(j), orderbook.NewOrder(uint64(benchmarkLevels+j), 1))
+				}
+
+				ladder.MatchOrderLimit(limit, orderbook.NewOrder(0, 2*sweep))
+			}
+		})
+	}
+}
+
+// Available walks the whole ladder without touching it, the way
+// fill-or-kill orders get checked.
+func BenchmarkLadder_Available(b *testing.B) {
+	for _, x := range backends() {
+		b.Run(x.name, func(b *testing.B) {
+			ladder := orderbook.NewLadderWith(orderbook.Ask, x.factory)
+
+			for i := 0; i < benchmarkLevels; i++ {
+				ladder.AddOrder(benchmarkPrice(i), orderbook.NewOrder(uint64(i), 1))
+			}
+
+			limit := benchmarkPrice(benchmarkLevels - 1)
+			taker := orderbook.NewOrder(0, benchmarkLevels)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				ladder.Available(limit, taker)
+			}
+		})
+	}
+}
+
+func BenchmarkBook_GetSnapshot(b *testing.B) {
+	for _, x := range backends() {
+		b.Run(x.name, func(b *testing.B) {
+			book := orderbook.NewBook(orderbook.WithInstrument(tickInstrument()), orderbook.WithLadderBackend(x.factory))
+
+			for i := 0; i < benchmarkLevels; i++ {
+				order := limitOrder(strconv.Itoa(i), orderbook.SideSell, benchmarkPrice(i), 1)
+				if _, err := book.AddOrder(order); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				book.GetSnapshot(20)
+			}
+		})
+	}
+}
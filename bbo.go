@@ -0,0 +1,71 @@
+package orderbook
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// BBO is the top of the book.  Quantities are visible ones.  A missing
+// side has zero price and quantity, and then spread, mid price and
+// microprice are zero too.
+type BBO struct {
+	HasBid      bool            `json:"hasBid"`
+	BidPrice    decimal.Decimal `json:"bidPrice"`
+	BidQuantity decimal.Decimal `json:"bidQuantity"`
+	HasAsk      bool            `json:"hasAsk"`
+	AskPrice    decimal.Decimal `json:"askPrice"`
+	AskQuantity decimal.Decimal `json:"askQuantity"`
+	Spread      decimal.Decimal `json:"spread"`
+	MidPrice    decimal.Decimal `json:"midPrice"`
+	MicroPrice  decimal.Decimal `json:"microPrice"` // Mid price weighted by the quantity on the other side.
+	Sequence    uint64          `json:"sequence"`   // Sequence number of the last event reflected.
+}
+
+// BBO returns the best bid and offer of the book.
+func (b *Book) BBO() BBO {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.bbo()
+}
+
+// bbo reads the best levels of both sides right off the ladders.  The
+// caller must hold b.mu.
+func (b *Book) bbo() BBO {
+	ans := BBO{
+		HasBid:      b.Bids.Len() > 0,
+		BidPrice:    decimal.Zero,
+		BidQuantity: decimal.Zero,
+		HasAsk:      b.Asks.Len() > 0,
+		AskPrice:    decimal.Zero,
+		AskQuantity: decimal.Zero,
+		Spread:      decimal.Zero,
+		MidPrice:    decimal.Zero,
+		MicroPrice:  decimal.Zero,
+		Sequence:    b.sequence,
+	}
+
+	if ans.HasBid {
+		best := b.clientLevel(b.Bids.Best())
+		ans.BidPrice, ans.BidQuantity = best.Price, best.Quantity
+	}
+
+	if ans.HasAsk {
+		best := b.clientLevel(b.Asks.Best())
+		ans.AskPrice, ans.AskQuantity = best.Price, best.Quantity
+	}
+
+	if !ans.HasBid || !ans.HasAsk {
+		return ans
+	}
+
+	ans.Spread = ans.AskPrice.Sub(ans.BidPrice)
+	ans.MidPrice = ans.AskPrice.Add(ans.BidPrice).Div(decimal.NewFromInt(2))
+
+	// Levels always have some visible quantity, so this never divides by
+	// zero.
+	ans.MicroPrice = ans.BidPrice.Mul(ans.AskQuantity).
+		Add(ans.AskPrice.Mul(ans.BidQuantity)).
+		Div(ans.BidQuantity.Add(ans.AskQuantity))
+
+	return ans
+}
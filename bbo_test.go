@@ -0,0 +1,57 @@
+package orderbook_test
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+func TestBook_BBO(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+
+	if bbo := b.BBO(); bbo.HasBid || bbo.HasAsk || !bbo.MidPrice.IsZero() {
+		t.Errorf("have %+v, want an empty book", bbo)
+	}
+
+	submit(t, b, limitOrder("buy99", orderbook.SideBuy, 99, 3))
+	submit(t, b, limitOrder("buy98", orderbook.SideBuy, 98, 5))
+
+	// One-sided books have no spread.
+	bbo := b.BBO()
+	if !bbo.HasBid || bbo.HasAsk || !bbo.BidPrice.Equal(decimal.NewFromInt(99)) || !bbo.Spread.IsZero() {
+		t.Errorf("have %+v, want bids only", bbo)
+	}
+
+	iceberg := limitOrder("sell101", orderbook.SideSell, 101, 10)
+	iceberg.DisplayQuantity = decimal.NewFromInt(1)
+
+	submit(t, b, iceberg)
+
+	bbo = b.BBO()
+
+	expected := []struct {
+		name       string
+		have, want decimal.Decimal
+	}{
+		{"bid price", bbo.BidPrice, decimal.NewFromInt(99)},
+		{"bid quantity", bbo.BidQuantity, decimal.NewFromInt(3)},
+		{"ask price", bbo.AskPrice, decimal.NewFromInt(101)},
+		{"ask quantity", bbo.AskQuantity, decimal.NewFromInt(1)}, // Visible only.
+		{"spread", bbo.Spread, decimal.NewFromInt(2)},
+		{"mid price", bbo.MidPrice, decimal.NewFromInt(100)},
+		{"microprice", bbo.MicroPrice, decimal.RequireFromString("100.5")},
+	}
+
+	for _, x := range expected {
+		if !x.have.Equal(x.want) {
+			t.Errorf("have %s %v, want %v", x.name, x.have, x.want)
+		}
+	}
+
+	if snapshot := b.GetSnapshot(0); bbo.Sequence != snapshot.Sequence {
+		t.Errorf("have sequence %d, want %d", bbo.Sequence, snapshot.Sequence)
+	}
+}
@@ -0,0 +1,250 @@
+package orderbook
+
+import (
+	"math/big"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// MaxCandles is the most candles returned at once.  It is also how many
+// candles with trades a book keeps per interval, older ones get dropped.
+const MaxCandles = 1000
+
+// DefaultCandleIntervals returns the candle intervals books keep by
+// default.
+func DefaultCandleIntervals() []time.Duration {
+	return []time.Duration{time.Second, time.Minute, 5 * time.Minute, time.Hour}
+}
+
+// Candle sums up the trades of a time interval.  Intervals without
+// trades get a flat candle at the previous close, with no volume.
+type Candle struct {
+	Time        time.Time       `json:"time"` // Open time.
+	Open        decimal.Decimal `json:"open"`
+	High        decimal.Decimal `json:"high"`
+	Low         decimal.Decimal `json:"low"`
+	Close       decimal.Decimal `json:"close"`
+	Volume      decimal.Decimal `json:"volume"`
+	QuoteVolume decimal.Decimal `json:"quoteVolume"` // Total value of the trades.
+	Trades      int             `json:"trades"`
+	VWAP        decimal.Decimal `json:"vwap"` // Volume-weighted average price.
+}
+
+// candle is what books keep of a Candle.  Prices are in ticks and
+// quantities in lots, so adding trades takes no decimal arithmetic, it
+// all gets converted once the candle is read.
+type candle struct {
+	time        time.Time
+	open        int64
+	high        int64
+	low         int64
+	close       int64
+	volume      uint128
+	quoteVolume uint128 // In ticks times lots.
+	trades      int
+}
+
+func newCandle(start time.Time, price int64) candle {
+	return candle{
+		time:        start,
+		open:        price,
+		high:        price,
+		low:         price,
+		close:       price,
+		volume:      uint128{hi: 0, lo: 0},
+		quoteVolume: uint128{hi: 0, lo: 0},
+		trades:      0,
+	}
+}
+
+// add updates the candle with the given trade.
+func (c *candle) add(trade Trade) {
+	if trade.ticks > c.high {
+		c.high = trade.ticks
+	}
+
+	if trade.ticks < c.low {
+		c.low = trade.ticks
+	}
+
+	c.close = trade.ticks
+	c.volume.add(uint64(trade.lots), 1)
+	c.quoteVolume.add(uint64(trade.lots), uint64(trade.ticks))
+	c.trades++
+}
+
+// export converts the candle into prices and quantities of the given
+// instrument.  Candles without volume have a VWAP of their price.
+func (c candle) export(instrument Instrument) Candle {
+	volume, quoteVolume := exportVolumes(instrument, c.volume, c.quoteVolume)
+
+	vwap := instrument.Price(c.close)
+	if volume.IsPositive() {
+		vwap = quoteVolume.Div(volume)
+	}
+
+	return Candle{
+		Time:        c.time,
+		Open:        instrument.Price(c.open),
+		High:        instrument.Price(c.high),
+		Low:         instrument.Price(c.low),
+		Close:       instrument.Price(c.close),
+		Volume:      volume,
+		QuoteVolume: quoteVolume,
+		Trades:      c.trades,
+		VWAP:        vwap,
+	}
+}
+
+// exportVolumes converts the given volume in lots and quote volume in
+// ticks times lots into quantities of the given instrument.
+func exportVolumes(instrument Instrument, volume, quoteVolume uint128) (decimal.Decimal, decimal.Decimal) {
+	return volume.decimal().Mul(instrument.LotSize),
+		quoteVolume.decimal().Mul(instrument.TickSize).Mul(instrument.LotSize)
+}
+
+// uint128 is an unsigned 128-bit integer.  Volumes get summed up in it,
+// as even the volume in lots of a single candle may not fit in 64 bits.
+type uint128 struct {
+	hi uint64
+	lo uint64
+}
+
+// add adds the product of x and y.
+func (u *uint128) add(x, y uint64) {
+	hi, lo := bits.Mul64(x, y)
+
+	var carry uint64
+
+	u.lo, carry = bits.Add64(u.lo, lo, 0)
+	u.hi += hi + carry
+}
+
+func (u *uint128) addAll(v uint128) {
+	var carry uint64
+
+	u.lo, carry = bits.Add64(u.lo, v.lo, 0)
+	u.hi += v.hi + carry
+}
+
+func (u uint128) decimal() decimal.Decimal {
+	x := new(big.Int).SetUint64(u.hi)
+	x.Lsh(x, 64).Or(x, new(big.Int).SetUint64(u.lo))
+
+	return decimal.NewFromBigInt(x, 0)
+}
+
+// candleSeries keeps the candles of a single interval.  Only the ones
+// with trades get stored, oldest first.
+type candleSeries struct {
+	interval time.Duration
+	candles  []candle
+	limit    int // Most candles stored, zero means no limit.
+}
+
+// WithCandleIntervals sets the intervals the book keeps candles for.
+// They must be positive.
+func WithCandleIntervals(intervals ...time.Duration) BookOption {
+	return func(b *Book) {
+		b.candleIntervals = intervals
+	}
+}
+
+func newCandleSeries(interval time.Duration, limit int) candleSeries {
+	return candleSeries{interval: interval, candles: make([]candle, 0), limit: limit}
+}
+
+// add updates the candle of the given trade, starting a new one if
+// needed.
+func (s *candleSeries) add(trade Trade) {
+	start := trade.Time.Truncate(s.interval)
+
+	if n := len(s.candles); n == 0 || s.candles[n-1].time.Before(start) {
+		if s.limit > 0 && n >= s.limit {
+			s.candles = s.candles[n-s.limit+1:]
+		}
+
+		s.candles = append(s.candles, newCandle(start, trade.ticks))
+	}
+
+	s.candles[len(s.candles)-1].add(trade)
+}
+
+// addCandles feeds the given trade into the candles of all intervals.
+// The caller must hold b.mu.
+func (b *Book) addCandles(trade Trade) {
+	for i := range b.candles {
+		b.candles[i].add(trade)
+	}
+}
+
+// Candles returns up to MaxCandles candles of the given interval opening
+// within [from, to), oldest first.  There are no candles before the
+// oldest one kept nor after the current one, see MaxCandles.  A zero to
+// means until now and a zero from means the last MaxCandles intervals
+// before to.
+func (b *Book) Candles(interval time.Duration, from, to time.Time) ([]Candle, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var s *candleSeries
+
+	for i := range b.candles {
+		if b.candles[i].interval == interval {
+			s = &b.candles[i]
+		}
+	}
+
+	if s == nil {
+		return nil, ErrInvalidInterval
+	}
+
+	ans := make([]Candle, 0)
+	if len(s.candles) == 0 {
+		return ans, nil
+	}
+
+	if limit := b.now().Truncate(interval).Add(interval); to.IsZero() || to.After(limit) {
+		to = limit
+	}
+
+	start := from.Truncate(interval)
+	if from.IsZero() {
+		// The first candle of the last MaxCandles opening before to.
+		start = to.Add(-MaxCandles * interval)
+		if t := start.Truncate(interval); t.Before(start) {
+			start = t.Add(interval)
+		}
+	}
+
+	if first := s.candles[0].time; start.Before(first) {
+		start = first
+	}
+
+	// The first candle at or after start, the one before it gives the
+	// close of the gaps.
+	i := sort.Search(len(s.candles), func(i int) bool {
+		return !s.candles[i].time.Before(start)
+	})
+
+	var last candle
+	if i > 0 {
+		last = s.candles[i-1]
+	}
+
+	for t := start; t.Before(to) && len(ans) < MaxCandles; t = t.Add(interval) {
+		if i < len(s.candles) && s.candles[i].time.Equal(t) {
+			last = s.candles[i]
+			i++
+		} else {
+			last = newCandle(t, last.close)
+		}
+
+		ans = append(ans, last.export(b.Instrument))
+	}
+
+	return ans, nil
+}
@@ -0,0 +1,164 @@
+package orderbook_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+func TestBook_Candles(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	b := orderbook.NewBook(orderbook.WithClock(func() time.Time { return now }),
+		orderbook.WithCandleIntervals(time.Minute))
+
+	if candles, err := b.Candles(time.Minute, time.Time{}, time.Time{}); err != nil || len(candles) != 0 {
+		t.Errorf("have %v, %v, want no candles", candles, err)
+	}
+
+	submit(t, b, limitOrder("sell100", orderbook.SideSell, 100, 5))
+	submit(t, b, limitOrder("sell101", orderbook.SideSell, 101, 5))
+
+	now = start.Add(10 * time.Second)
+	submit(t, b, marketOrder("buy1", orderbook.SideBuy, 2))
+
+	now = start.Add(40 * time.Second)
+	submit(t, b, marketOrder("buy2", orderbook.SideBuy, 4))
+
+	now = start.Add(3*time.Minute + 5*time.Second)
+	submit(t, b, marketOrder("buy3", orderbook.SideBuy, 1))
+
+	now = start.Add(4*time.Minute + 30*time.Second)
+
+	candles, err := b.Candles(time.Minute, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Minutes without trades stay flat at the previous close.
+	expected := []struct {
+		open, high, low, close, volume string
+		trades                         int
+	}{
+		{"100", "101", "100", "101", "6", 3},
+		{"101", "101", "101", "101", "0", 0},
+		{"101", "101", "101", "101", "0", 0},
+		{"101", "101", "101", "101", "1", 1},
+		{"101", "101", "101", "101", "0", 0},
+	}
+
+	if len(candles) != len(expected) {
+		t.Fatalf("have %d candles, want %d", len(candles), len(expected))
+	}
+
+	for i, x := range expected {
+		candle := candles[i]
+		have := []decimal.Decimal{candle.Open, candle.High, candle.Low, candle.Close, candle.Volume}
+
+		for j, want := range []string{x.open, x.high, x.low, x.close, x.volume} {
+			if !have[j].Equal(decimal.RequireFromString(want)) {
+				t.Errorf("candle %d: have %v, want %s", i, have, want)
+			}
+		}
+
+		if candle.Trades != x.trades || !candle.Time.Equal(start.Add(time.Duration(i)*time.Minute)) {
+			t.Errorf("candle %d: have %d trades at %v", i, candle.Trades, candle.Time)
+		}
+	}
+
+	if vwap := decimal.NewFromInt(601).Div(decimal.NewFromInt(6)); !candles[0].VWAP.Equal(vwap) {
+		t.Errorf("have VWAP %v, want %v", candles[0].VWAP, vwap)
+	}
+
+	candles, err = b.Candles(time.Minute, start.Add(2*time.Minute), start.Add(3*time.Minute+30*time.Second))
+	if err != nil || len(candles) != 2 || candles[1].Trades != 1 {
+		t.Errorf("have %v, %v, want 2 candles", candles, err)
+	}
+
+	if _, err := b.Candles(time.Hour, time.Time{}, time.Time{}); !errors.Is(err, orderbook.ErrInvalidInterval) {
+		t.Errorf("have %v, want ErrInvalidInterval", err)
+	}
+}
+
+func TestBook_Candles_Retention(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	b := orderbook.NewBook(orderbook.WithClock(func() time.Time { return now }),
+		orderbook.WithCandleIntervals(time.Minute))
+
+	// A trade every other minute, so there are gaps between the candles.
+	for i := 0; i < orderbook.MaxCandles+5; i++ {
+		now = start.Add(time.Duration(2*i) * time.Minute)
+
+		submit(t, b, limitOrder("sell"+strconv.Itoa(i), orderbook.SideSell, int64(100+i), 1))
+		submit(t, b, marketOrder("buy"+strconv.Itoa(i), orderbook.SideBuy, 1))
+	}
+
+	candles, err := b.Candles(time.Minute, start, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The oldest candles got dropped.
+	if first := start.Add(10 * time.Minute); len(candles) == 0 || !candles[0].Time.Equal(first) {
+		t.Fatalf("have %v, want candles from %v", candles, first)
+	}
+
+	if !candles[0].Open.Equal(decimal.NewFromInt(105)) || !candles[0].VWAP.Equal(decimal.NewFromInt(105)) {
+		t.Errorf("have %v, want open and VWAP 105", candles[0])
+	}
+
+	// Gaps stay flat at the previous close.
+	if !candles[1].Open.Equal(decimal.NewFromInt(105)) || candles[1].Trades != 0 {
+		t.Errorf("have %v, want a flat candle at 105", candles[1])
+	}
+}
+
+// Without from, the candles lead up to the current one, however sparse
+// the trades are.
+func TestBook_Candles_Sparse(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	b := orderbook.NewBook(orderbook.WithClock(func() time.Time { return now }),
+		orderbook.WithCandleIntervals(time.Second))
+
+	submit(t, b, limitOrder("sell100", orderbook.SideSell, 100, 1))
+	submit(t, b, limitOrder("sell101", orderbook.SideSell, 101, 1))
+	submit(t, b, marketOrder("buy1", orderbook.SideBuy, 1))
+
+	now = start.Add(time.Hour)
+	submit(t, b, marketOrder("buy2", orderbook.SideBuy, 1))
+
+	candles, err := b.Candles(time.Second, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(candles) != orderbook.MaxCandles {
+		t.Fatalf("have %d candles, want %d", len(candles), orderbook.MaxCandles)
+	}
+
+	if first := now.Add(-(orderbook.MaxCandles - 1) * time.Second); !candles[0].Time.Equal(first) {
+		t.Errorf("have first candle at %v, want %v", candles[0].Time, first)
+	}
+
+	last := candles[len(candles)-1]
+	if !last.Time.Equal(now) || last.Trades != 1 || !last.Close.Equal(decimal.NewFromInt(101)) {
+		t.Errorf("have last candle %v, want the trade at 101 at %v", last, now)
+	}
+
+	// Gaps before the window stay flat at the previous close.
+	if !candles[0].Open.Equal(decimal.NewFromInt(100)) || candles[0].Trades != 0 {
+		t.Errorf("have first candle %v, want a flat one at 100", candles[0])
+	}
+}
@@ -1,10 +1,16 @@
 package orderbook
 
-import "github.com/shopspring/decimal"
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
 
 const (
 	TypeLimit = iota
 	TypeMarket
+	TypeStop      // Market order placed once the stop price is reached.
+	TypeStopLimit // Limit order placed once the stop price is reached.
 )
 
 const (
@@ -18,17 +24,91 @@ const (
 	StateFilled
 	StatePartiallyFilled
 	StateCanceled
+	StateExpired
+	StateRejected
+	StatePendingTrigger // Stop order waiting for its stop price.
+)
+
+const (
+	TimeInForceGTC = iota // Good till canceled.
+	TimeInForceIOC        // Immediate or cancel.
+	TimeInForceFOK        // Fill or kill.
+	TimeInForceGTD        // Good till date, see ClientOrder.ExpireTime.
+	TimeInForceDAY        // Good till the end of the (UTC) day.
+)
+
+const (
+	PostOnlyNone   = iota
+	PostOnlyReject // Reject the order if it would take liquidity.
+	PostOnlySlide  // Reprice the order one tick away from the touch instead.
+)
+
+// Self-trade prevention modes decide what happens when an order would
+// trade against a resting order of the same owner.  The taker's mode
+// applies.
+const (
+	SelfTradeCancelNewest = iota // Cancel the taker.
+	SelfTradeCancelOldest        // Cancel the maker and keep matching.
+	SelfTradeCancelBoth
+	SelfTradeDecrement // Decrement both by the smaller quantity, canceling whichever gets to zero.
+	SelfTradeAllow
 )
 
 type ClientOrder struct {
-	// Symbol           string
-	Side             int             `json:"side"`
-	OriginalQuantity decimal.Decimal `json:"quantity"`
-	ExecutedQuantity decimal.Decimal `json:"executedQuantity"`
-	Price            decimal.Decimal `json:"price"`
-	ID               string          `json:"id"`
-	Type             int             `json:"type"`
-	// State            int
+	Symbol            string          `json:"symbol"`
+	Side              int             `json:"side"`
+	OriginalQuantity  decimal.Decimal `json:"quantity"`
+	QuoteQuantity     decimal.Decimal `json:"quoteQuantity"` // Market orders only, sizes the order in quote currency.
+	ExecutedQuantity  decimal.Decimal `json:"executedQuantity"`
+	LeavesQuantity    decimal.Decimal `json:"leavesQuantity"`
+	PreventedQuantity decimal.Decimal `json:"preventedQuantity"` // Decremented by self-trade prevention.
+	DisplayQuantity   decimal.Decimal `json:"displayQuantity"`   // Iceberg orders only.
+	VisibleQuantity   decimal.Decimal `json:"visibleQuantity"`   // Currently shown in the book.
+	Price             decimal.Decimal `json:"price"`
+	StopPrice         decimal.Decimal `json:"stopPrice"`
+	MaxSlippage       decimal.Decimal `json:"maxSlippage"` // Market orders only, relative to the touch, e.g. 0.01 is 1%.
+	ID                string          `json:"id"`          // Assigned by the client.
+	Handle            uint64          `json:"handle"`      // Assigned by the book once it records the order.
+	Type              int             `json:"type"`
+	TimeInForce       int             `json:"timeInForce"`
+	PostOnly          int             `json:"postOnly"`
+	Owner             string          `json:"owner"`               // Account of the trader, optional.
+	SelfTrade         int             `json:"selfTradePrevention"` // Applies only if Owner is set.
+	ExpireTime        time.Time       `json:"expireTime"`
+	State             int             `json:"state"`
+	Reason            string          `json:"reason"` // Why the order got canceled or rejected.
+
+	units orderUnits // Set by the book once it validated the order.
+}
+
+// orderUnits keeps the prices of an order in ticks and its quantities in
+// lots of the instrument, see Instrument.Ticks and Instrument.Lots.  The
+// book converts an order only once, when validating it, and keeps the
+// units in step with the decimal fields from then on.
+type orderUnits struct {
+	price     int64
+	stopPrice int64
+	quantity  int64 // Original quantity.
+	display   int64
+	executed  int64
+	prevented int64
+}
+
+// leaves returns the quantity left to execute, unless the order is
+// final.
+func (u orderUnits) leaves() int64 {
+	return u.quantity - u.executed - u.prevented
+}
+
+// IsMarket reports whether orders of the given type execute as market
+// orders.
+func IsMarket(orderType int) bool {
+	return orderType == TypeMarket || orderType == TypeStop
+}
+
+// IsStop reports whether orders of the given type wait for a stop price.
+func IsStop(orderType int) bool {
+	return orderType == TypeStop || orderType == TypeStopLimit
 }
 
 type ClientLevel struct {
@@ -37,6 +117,30 @@ type ClientLevel struct {
 }
 
 type Snapshot struct {
-	Asks []ClientLevel
-	Bids []ClientLevel
+	Asks     []ClientLevel
+	Bids     []ClientLevel
+	Sequence uint64 // Sequence number of the last event reflected.
+}
+
+// ClientRestingOrder is an order resting at a level of an L3 snapshot.
+// Iceberg orders show only their visible quantity.
+type ClientRestingOrder struct {
+	ID        string          `json:"id"`
+	Handle    uint64          `json:"handle"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	Insertion int             `json:"insertion"` // Increases with each order joining the level.
+}
+
+// ClientL3Level is a level of an L3 snapshot along with its orders, in
+// the order they execute.
+type ClientL3Level struct {
+	Price    decimal.Decimal      `json:"price"`
+	Quantity decimal.Decimal      `json:"quantity"`
+	Orders   []ClientRestingOrder `json:"orders"`
+}
+
+type L3Snapshot struct {
+	Asks     []ClientL3Level
+	Bids     []ClientL3Level
+	Sequence uint64 // Sequence number of the last event reflected.
 }
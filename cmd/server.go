@@ -3,20 +3,27 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
 	"github.com/ydm/orderbook"
 )
 
-type BookKeyType int
+type ContextKeyType int
 
-const BookKey = BookKeyType(1601486424)
+const (
+	ExchangeKey = ContextKeyType(1601486424)
+	SymbolKey   = ContextKeyType(1601486425) // Default symbol.
+)
 
 type Response struct {
 	Response interface{} `json:"response"`
@@ -41,6 +48,39 @@ func logf(format string, a ...interface{}) {
 	fmt.Printf(format, a...)
 }
 
+func getExchange(request *http.Request) *orderbook.Exchange {
+	exchange, ok := request.Context().Value(ExchangeKey).(*orderbook.Exchange)
+	if !ok {
+		panic("")
+	}
+
+	return exchange
+}
+
+// getSymbol returns the symbol addressed by the request: either the
+// {symbol} route variable, the "symbol" query parameter or the default
+// symbol, in this order.
+func getSymbol(request *http.Request) string {
+	if symbol, ok := mux.Vars(request)["symbol"]; ok {
+		return symbol
+	}
+
+	if symbol := request.URL.Query().Get("symbol"); symbol != "" {
+		return symbol
+	}
+
+	symbol, ok := request.Context().Value(SymbolKey).(string)
+	if !ok {
+		panic("")
+	}
+
+	return symbol
+}
+
+func getBook(request *http.Request) (*orderbook.Book, error) {
+	return getExchange(request).Book(getSymbol(request))
+}
+
 func respond(writer http.ResponseWriter, response Response) {
 	encoded, err := json.Marshal(response)
 	if err != nil {
@@ -55,41 +95,67 @@ func respond(writer http.ResponseWriter, response Response) {
 // | (1) Submit order |
 // +------------------+
 
-func addOrder(writer http.ResponseWriter, request *http.Request) {
+// parseOrder reads the order from the request body.  Orders submitted to
+// /symbols/{symbol}/orders must be for that symbol, others default to
+// the default symbol.
+func parseOrder(request *http.Request) (orderbook.ClientOrder, error) {
+	var order orderbook.ClientOrder
+
 	body, err := io.ReadAll(request.Body)
 	if err != nil {
-		respond(writer, Response{Response: nil, Error: err.Error()})
-
-		return
+		return order, err
 	}
 
-	var order orderbook.ClientOrder
 	if err := json.Unmarshal(body, &order); err != nil {
-		respond(writer, Response{Response: nil, Error: err.Error()})
-
-		return
+		return order, err
 	}
 
-	book, ok := request.Context().Value(BookKey).(*orderbook.Book)
-	if !ok {
-		panic("")
+	if symbol := getSymbol(request); order.Symbol == "" {
+		order.Symbol = symbol
+	} else if _, ok := mux.Vars(request)["symbol"]; ok && order.Symbol != symbol {
+		return order, orderbook.ErrInvalidSymbol
 	}
 
-	if err := book.AddOrder(order); err != nil {
+	return order, nil
+}
+
+func addOrder(writer http.ResponseWriter, request *http.Request) {
+	order, err := parseOrder(request)
+	if err != nil {
 		respond(writer, Response{Response: nil, Error: err.Error()})
 
 		return
 	}
 
-	// Return order's current status.
-	order, err = book.GetOrder(order.ID)
+	report, err := getExchange(request).AddOrder(order)
+
+	// Rejected orders and orders that did not (fully) execute are still
+	// recorded, so their state and fills get reported along with the error.
+	switch {
+	case err == nil:
+		respond(writer, Response{Response: report, Error: ""})
+	case report.Order.ID != "":
+		respond(writer, Response{Response: report, Error: err.Error()})
+	default:
+		respond(writer, Response{Response: nil, Error: err.Error()})
+	}
+}
+
+// simulateOrder responds with what the order would execute, see
+// Book.Simulate.
+func simulateOrder(writer http.ResponseWriter, request *http.Request) {
+	order, err := parseOrder(request)
 	if err != nil {
 		respond(writer, Response{Response: nil, Error: err.Error()})
 
 		return
 	}
 
-	respond(writer, Response{Response: order, Error: ""})
+	if simulation, err := getExchange(request).Simulate(order); err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+	} else {
+		respond(writer, Response{Response: simulation, Error: ""})
+	}
 }
 
 // +------------------+
@@ -100,12 +166,7 @@ func cancelOrder(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	orderID := vars["id"]
 
-	b, ok := request.Context().Value(BookKey).(*orderbook.Book)
-	if !ok {
-		panic("")
-	}
-
-	if err := b.CancelOrder(orderID); err == nil {
+	if err := getExchange(request).CancelOrder(orderID); err == nil {
 		respond(writer, Response{Response: true, Error: ""})
 	} else {
 		respond(writer, Response{Response: false, Error: err.Error()})
@@ -120,29 +181,95 @@ func queryOrder(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	orderID := vars["id"]
 
-	b, ok := request.Context().Value(BookKey).(*orderbook.Book)
-	if !ok {
-		panic("")
+	if order, err := getExchange(request).GetOrder(orderID); err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+	} else {
+		respond(writer, Response{Response: order, Error: ""})
+	}
+}
+
+func queryOrderByHandle(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+
+	handle, err := strconv.ParseUint(vars["handle"], 10, 64)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
 	}
 
-	if order, err := b.GetOrder(orderID); err != nil {
+	if order, err := getExchange(request).GetOrderByHandle(handle); err != nil {
 		respond(writer, Response{Response: nil, Error: err.Error()})
 	} else {
 		respond(writer, Response{Response: order, Error: ""})
 	}
 }
 
+func queuePosition(writer http.ResponseWriter, request *http.Request) {
+	vars := mux.Vars(request)
+	orderID := vars["id"]
+
+	if position, err := getExchange(request).QueuePosition(orderID); err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+	} else {
+		respond(writer, Response{Response: position, Error: ""})
+	}
+}
+
+// +-----------------+
+// | (4) Amend order |
+// +-----------------+
+
+type amendRequest struct {
+	Price    decimal.Decimal `json:"price"`    // Zero keeps the current price.
+	Quantity decimal.Decimal `json:"quantity"` // Zero keeps the current quantity.
+}
+
+func amendOrder(writer http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	var amend amendRequest
+	if err := json.Unmarshal(body, &amend); err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	vars := mux.Vars(request)
+	orderID := vars["id"]
+
+	if report, err := getExchange(request).AmendOrder(orderID, amend.Price, amend.Quantity); err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+	} else {
+		respond(writer, Response{Response: report, Error: ""})
+	}
+}
+
 // +-------------------------+
 // | (5) Order book snapshot |
 // +-------------------------+
 
 type bookResponse struct {
-	// Symbol string                  `json:"symbol"`
-	Asks []orderbook.ClientLevel `json:"asks"`
-	Bids []orderbook.ClientLevel `json:"bids"`
+	Symbol   string                  `json:"symbol"`
+	Sequence uint64                  `json:"sequence"`
+	Asks     []orderbook.ClientLevel `json:"asks"`
+	Bids     []orderbook.ClientLevel `json:"bids"`
 }
 
-func book(writer http.ResponseWriter, request *http.Request) {
+type l3Response struct {
+	Symbol   string                    `json:"symbol"`
+	Sequence uint64                    `json:"sequence"`
+	Asks     []orderbook.ClientL3Level `json:"asks"`
+	Bids     []orderbook.ClientL3Level `json:"bids"`
+}
+
+// getDepth returns the depth query parameter, 20 by default.
+func getDepth(request *http.Request) int {
 	depths, depthsOK := request.URL.Query()["depth"]
 	if !depthsOK {
 		depths = []string{"20"}
@@ -153,23 +280,221 @@ func book(writer http.ResponseWriter, request *http.Request) {
 		depth = 20
 	}
 
-	book, bookOK := request.Context().Value(BookKey).(*orderbook.Book)
-	if !bookOK {
-		panic("")
+	return depth
+}
+
+func book(writer http.ResponseWriter, request *http.Request) {
+	depth := getDepth(request)
+
+	book, err := getBook(request)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
 	}
 
 	snapshot := book.GetSnapshot(depth)
 
 	respond(writer, Response{
 		Response: bookResponse{
-			Asks: snapshot.Asks,
-			Bids: snapshot.Bids,
+			Symbol:   book.Symbol,
+			Sequence: snapshot.Sequence,
+			Asks:     snapshot.Asks,
+			Bids:     snapshot.Bids,
+		},
+		Error: "",
+	})
+}
+
+func bookL3(writer http.ResponseWriter, request *http.Request) {
+	depth := getDepth(request)
+
+	book, err := getBook(request)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	snapshot := book.GetL3Snapshot(depth)
+
+	respond(writer, Response{
+		Response: l3Response{
+			Symbol:   book.Symbol,
+			Sequence: snapshot.Sequence,
+			Asks:     snapshot.Asks,
+			Bids:     snapshot.Bids,
 		},
 		Error: "",
 	})
 }
 
+func ticker(writer http.ResponseWriter, request *http.Request) {
+	book, err := getBook(request)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	respond(writer, Response{Response: book.BBO(), Error: ""})
+}
+
+func bookUpdates(writer http.ResponseWriter, request *http.Request) {
+	since, err := strconv.ParseUint(request.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: orderbook.ErrInvalidSequence.Error()})
+
+		return
+	}
+
+	book, err := getBook(request)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	if diff, err := book.Diff(since); err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+	} else {
+		respond(writer, Response{Response: diff, Error: ""})
+	}
+}
+
+// +------------+
+// | (6) Trades |
+// +------------+
+
+func trades(writer http.ResponseWriter, request *http.Request) {
+	limit, err := strconv.Atoi(request.URL.Query().Get("limit"))
+	if err != nil {
+		limit = 100
+	}
+
+	book, err := getBook(request)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	respond(writer, Response{Response: book.GetTrades(limit), Error: ""})
+}
+
+func stats(writer http.ResponseWriter, request *http.Request) {
+	book, err := getBook(request)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	respond(writer, Response{Response: book.Stats(), Error: ""})
+}
+
+// parseTime parses an RFC 3339 time, empty means the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// candles responds with the candles of the given interval, e.g. "1m",
+// between the given RFC 3339 times.  Both times are optional.
+func candles(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	interval, err := time.ParseDuration(query.Get("interval"))
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: orderbook.ErrInvalidInterval.Error()})
+
+		return
+	}
+
+	from, err := parseTime(query.Get("from"))
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	to, err := parseTime(query.Get("to"))
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	book, err := getBook(request)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	if candles, err := book.Candles(interval, from, to); err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+	} else {
+		respond(writer, Response{Response: candles, Error: ""})
+	}
+}
+
+// +-------------+
+// | (7) Symbols |
+// +-------------+
+
+func listSymbols(writer http.ResponseWriter, request *http.Request) {
+	respond(writer, Response{Response: getExchange(request).Symbols(), Error: ""})
+}
+
+func querySymbol(writer http.ResponseWriter, request *http.Request) {
+	book, err := getBook(request)
+	if err != nil {
+		respond(writer, Response{Response: nil, Error: err.Error()})
+
+		return
+	}
+
+	respond(writer, Response{Response: book.Instrument, Error: ""})
+}
+
+// parseInstrument builds the instrument specification shared by all
+// books from the given tick and lot sizes.
+func parseInstrument(tickSize, lotSize string) orderbook.Instrument {
+	instrument := orderbook.DefaultInstrument()
+	instrument.TickSize = decimal.RequireFromString(tickSize)
+	instrument.LotSize = decimal.RequireFromString(lotSize)
+
+	if exponent := instrument.TickSize.Exponent(); exponent < 0 {
+		instrument.PricePrecision = -exponent
+	} else {
+		instrument.PricePrecision = 0
+	}
+
+	if exponent := instrument.LotSize.Exponent(); exponent < 0 {
+		instrument.QuantityPrecision = -exponent
+	} else {
+		instrument.QuantityPrecision = 0
+	}
+
+	if err := instrument.Validate(); err != nil {
+		panic(err)
+	}
+
+	return instrument
+}
+
 func main() {
+	symbolList := flag.String("symbols", "BTCUSDT", "comma-separated list of traded symbols, the first one is default")
+	tickSize := flag.String("tick-size", "0.00000001", "minimum price increment")
+	lotSize := flag.String("lot-size", "0.00000001", "minimum quantity increment")
+	flag.Parse()
+
+	instrument := parseInstrument(*tickSize, *lotSize)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -181,18 +506,78 @@ func main() {
 
 	router := mux.NewRouter().StrictSlash(true)
 	router.HandleFunc("/orders/", addOrder).Methods("POST")
+	router.HandleFunc("/orders/simulate", simulateOrder).Methods("POST")
 	router.HandleFunc("/orders/{id}", queryOrder).Methods("GET")
+	router.HandleFunc("/orders/{id}/position", queuePosition).Methods("GET")
+	router.HandleFunc("/handles/{handle}", queryOrderByHandle).Methods("GET")
 	router.HandleFunc("/orders/{id}", cancelOrder).Methods("DELETE")
+	router.HandleFunc("/orders/{id}", amendOrder).Methods("PATCH")
 	router.HandleFunc("/book/", book).Methods("GET")
+	router.HandleFunc("/book/l3", bookL3).Methods("GET")
+	router.HandleFunc("/book/updates", bookUpdates).Methods("GET")
+	router.HandleFunc("/ticker", ticker).Methods("GET")
+	router.HandleFunc("/trades", trades).Methods("GET")
+	router.HandleFunc("/candles", candles).Methods("GET")
+	router.HandleFunc("/stats", stats).Methods("GET")
+	router.HandleFunc("/symbols/", listSymbols).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}", querySymbol).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}/orders", addOrder).Methods("POST")
+	router.HandleFunc("/symbols/{symbol}/orders/simulate", simulateOrder).Methods("POST")
+	router.HandleFunc("/symbols/{symbol}/book", book).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}/book/l3", bookL3).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}/book/updates", bookUpdates).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}/ticker", ticker).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}/trades", trades).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}/candles", candles).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}/stats", stats).Methods("GET")
+	router.HandleFunc("/stream", stream).Methods("GET")
+	router.HandleFunc("/symbols/{symbol}/stream", stream).Methods("GET")
+
+	exchange := orderbook.NewExchange()
+	symbols := strings.Split(*symbolList, ",")
+
+	for i, symbol := range symbols {
+		symbols[i] = strings.TrimSpace(symbol)
+
+		if _, err := exchange.AddBook(symbols[i], orderbook.WithInstrument(instrument)); err != nil {
+			panic(err)
+		}
+	}
+
+	defaultSymbol := symbols[0]
 
-	book := orderbook.NewBook()
 	handler := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ctx := context.WithValue(r.Context(), BookKey, book)
+			ctx := context.WithValue(r.Context(), ExchangeKey, exchange)
+			ctx = context.WithValue(ctx, SymbolKey, defaultSymbol)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 
+	// Periodically remove expired GTD and DAY orders from the books.
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				for _, symbol := range exchange.Symbols() {
+					book, err := exchange.Book(symbol)
+					if err != nil {
+						panic(err)
+					}
+
+					if expired := book.ExpireOrders(); len(expired) > 0 {
+						logf("INF: Expired %s orders: %v\n", symbol, expired)
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
 	var server http.Server
 	server.Addr = ":7701"
 	server.Handler = handler(router)
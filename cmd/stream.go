@@ -0,0 +1,387 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+// +------------+
+// | (8) Stream |
+// +------------+
+
+// The stream endpoint is a WebSocket.  Clients subscribe to symbols by
+// sending streamRequest messages.  For each subscribed symbol they first
+// get a depth snapshot, followed by depth updates and trades.
+
+var (
+	errFellBehind    = errors.New("stream fell behind, subscribe again")
+	errUnknownMethod = errors.New("unknown method")
+)
+
+const streamWriteTimeout = 10 * time.Second
+
+var upgrader = websocket.Upgrader{ //nolint:exhaustruct,gochecknoglobals
+	// UIs may be served from anywhere.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamRequest subscribes the connection to the depth updates and
+// trades of a symbol, or unsubscribes it.  Subscribing again changes the
+// depth and starts over with a new snapshot.
+type streamRequest struct {
+	Method string `json:"method"` // "subscribe" or "unsubscribe".
+	Symbol string `json:"symbol"` // Empty means the default symbol.
+	Depth  int    `json:"depth"`  // Levels per side, zero for all of them.
+}
+
+// streamMessage is either a depth snapshot, a depth update, a trade or an
+// error.  Each message of a symbol carries the sequence number of the
+// previous one, so clients can detect gaps and resubscribe.
+type streamMessage struct {
+	Type     string                  `json:"type"` // "snapshot", "update", "trade" or "error".
+	Symbol   string                  `json:"symbol"`
+	Sequence uint64                  `json:"sequence"` // Book sequence number.
+	Previous uint64                  `json:"previous"`
+	Asks     []orderbook.ClientLevel `json:"asks,omitempty"` // In updates zero quantity removes the level.
+	Bids     []orderbook.ClientLevel `json:"bids,omitempty"`
+	Trade    *orderbook.Trade        `json:"trade,omitempty"`
+	Error    string                  `json:"error,omitempty"`
+}
+
+type streamConnection struct {
+	conn *websocket.Conn
+	mu   sync.Mutex // Guards writes to conn.
+
+	// Streams get added by the reading goroutine and removed by it or,
+	// once their subscription is dropped, by themselves, see drop.
+	streams      map[string]*symbolStream
+	streamsMutex sync.Mutex
+}
+
+func (c *streamConnection) send(message streamMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		logf("WRN: Error while setting write deadline: %v\n", err)
+	}
+
+	// Slow or gone clients get disconnected, which stops the reading
+	// goroutine as well.
+	if err := c.conn.WriteJSON(message); err != nil {
+		logf("WRN: Error while streaming: %v\n", err)
+		c.conn.Close()
+	}
+}
+
+func (c *streamConnection) sendError(symbol string, err error) {
+	c.send(streamMessage{
+		Type:     "error",
+		Symbol:   symbol,
+		Sequence: 0,
+		Previous: 0,
+		Asks:     nil,
+		Bids:     nil,
+		Trade:    nil,
+		Error:    err.Error(),
+	})
+}
+
+func (c *streamConnection) subscribe(exchange *orderbook.Exchange, request streamRequest) error {
+	book, err := exchange.Book(request.Symbol)
+	if err != nil {
+		return err
+	}
+
+	c.unsubscribe(request.Symbol)
+
+	s := &symbolStream{
+		connection:   c,
+		symbol:       request.Symbol,
+		subscription: nil,
+		view:         depthView{depth: 0, asks: nil, bids: nil},
+		sequence:     0,
+		mu:           sync.Mutex{},
+	}
+
+	// Hold back the events until the snapshot is sent.  The ones already
+	// reflected in it get skipped.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.subscription = book.Subscribe(s.onEvent)
+	snapshot := book.GetSnapshot(math.MaxInt)
+	s.view = newDepthView(request.Depth, snapshot)
+	s.sequence = snapshot.Sequence
+
+	c.streamsMutex.Lock()
+	c.streams[request.Symbol] = s
+	c.streamsMutex.Unlock()
+
+	c.send(streamMessage{
+		Type:     "snapshot",
+		Symbol:   request.Symbol,
+		Sequence: snapshot.Sequence,
+		Previous: 0,
+		Asks:     s.view.top(orderbook.SideSell),
+		Bids:     s.view.top(orderbook.SideBuy),
+		Trade:    nil,
+		Error:    "",
+	})
+
+	return nil
+}
+
+func (c *streamConnection) unsubscribe(symbol string) {
+	c.streamsMutex.Lock()
+	s, ok := c.streams[symbol]
+	delete(c.streams, symbol)
+	c.streamsMutex.Unlock()
+
+	if ok {
+		s.subscription.Close()
+	}
+}
+
+// drop forgets the given stream after the book dropped its subscription,
+// unless the symbol got subscribed again meanwhile.
+func (c *streamConnection) drop(s *symbolStream) {
+	c.streamsMutex.Lock()
+	defer c.streamsMutex.Unlock()
+
+	if c.streams[s.symbol] == s {
+		delete(c.streams, s.symbol)
+	}
+}
+
+func (c *streamConnection) close() {
+	c.streamsMutex.Lock()
+	streams := c.streams
+	c.streams = make(map[string]*symbolStream)
+	c.streamsMutex.Unlock()
+
+	for _, s := range streams {
+		s.subscription.Close()
+	}
+
+	c.conn.Close()
+}
+
+// symbolStream forwards the events of a single book to a connection.
+type symbolStream struct {
+	connection   *streamConnection
+	symbol       string
+	subscription *orderbook.Subscription
+	view         depthView
+	sequence     uint64 // Of the last message sent.
+	mu           sync.Mutex
+}
+
+func (s *symbolStream) onEvent(event orderbook.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.Sequence <= s.sequence {
+		return
+	}
+
+	message := streamMessage{
+		Type:     "",
+		Symbol:   s.symbol,
+		Sequence: event.Sequence,
+		Previous: s.sequence,
+		Asks:     nil,
+		Bids:     nil,
+		Trade:    nil,
+		Error:    "",
+	}
+
+	switch event.Type {
+	case orderbook.EventGap:
+		// The book dropped the subscription, the client has to
+		// subscribe again.
+		s.connection.drop(s)
+		s.connection.sendError(s.symbol, errFellBehind)
+
+		return
+	case orderbook.EventTrade:
+		message.Type = "trade"
+		message.Trade = event.Trade
+	case orderbook.EventLevelChanged:
+		changes := s.view.update(*event.Level)
+		if len(changes) == 0 {
+			// Nothing changed within the subscribed depth.
+			return
+		}
+
+		message.Type = "update"
+
+		if event.Level.Side == orderbook.SideSell {
+			message.Asks = changes
+		} else {
+			message.Bids = changes
+		}
+	default:
+		return
+	}
+
+	s.sequence = event.Sequence
+	s.connection.send(message)
+}
+
+// depthView mirrors all levels of a book, so it can tell how the top
+// levels change, including the ones moving into the subscribed depth.
+// Levels are kept best first, so only the ones up to the depth need to
+// be looked at.
+type depthView struct {
+	depth int
+	asks  []orderbook.ClientLevel
+	bids  []orderbook.ClientLevel
+}
+
+func newDepthView(depth int, snapshot orderbook.Snapshot) depthView {
+	v := depthView{
+		depth: depth,
+		asks:  make([]orderbook.ClientLevel, len(snapshot.Asks)),
+		bids:  make([]orderbook.ClientLevel, len(snapshot.Bids)),
+	}
+
+	copy(v.asks, snapshot.Asks)
+	copy(v.bids, snapshot.Bids)
+
+	return v
+}
+
+func (v *depthView) levels(side int) *[]orderbook.ClientLevel {
+	if side == orderbook.SideSell {
+		return &v.asks
+	}
+
+	return &v.bids
+}
+
+// top returns the best levels of the given side, up to the depth.
+func (v *depthView) top(side int) []orderbook.ClientLevel {
+	levels := *v.levels(side)
+
+	if v.depth > 0 && len(levels) > v.depth {
+		levels = levels[:v.depth]
+	}
+
+	ans := make([]orderbook.ClientLevel, len(levels))
+	copy(ans, levels)
+
+	return ans
+}
+
+// update applies the given level update and returns the resulting
+// changes within the depth.
+func (v *depthView) update(update orderbook.LevelUpdate) []orderbook.ClientLevel {
+	levels := v.levels(update.Side)
+	xs := *levels
+
+	// The position of the level, or where it goes.
+	i := sort.Search(len(xs), func(i int) bool {
+		if update.Side == orderbook.SideSell {
+			return xs[i].Price.GreaterThanOrEqual(update.Price)
+		}
+
+		return xs[i].Price.LessThanOrEqual(update.Price)
+	})
+	found := i < len(xs) && xs[i].Price.Equal(update.Price)
+	level := orderbook.ClientLevel{Price: update.Price, Quantity: update.Quantity}
+
+	unchanged := (found && xs[i].Quantity.Equal(update.Quantity)) || (!found && update.Quantity.IsZero())
+
+	switch {
+	case unchanged:
+		// Nothing to apply.
+	case update.Quantity.IsZero():
+		*levels = append(xs[:i], xs[i+1:]...)
+	case found:
+		xs[i] = level
+	default:
+		xs = append(xs, level)
+		copy(xs[i+1:], xs[i:])
+		xs[i] = level
+		*levels = xs
+	}
+
+	if v.depth <= 0 {
+		return []orderbook.ClientLevel{level}
+	}
+
+	if unchanged || i >= v.depth {
+		// Nothing changed within the subscribed depth.
+		return nil
+	}
+
+	xs = *levels
+	changes := []orderbook.ClientLevel{level}
+
+	switch {
+	case update.Quantity.IsZero() && len(xs) >= v.depth:
+		// The next level moves into the top.
+		changes = []orderbook.ClientLevel{xs[v.depth-1], level}
+	case !update.Quantity.IsZero() && !found && len(xs) > v.depth:
+		// The last level of the top gets pushed out of it.
+		changes = append(changes, orderbook.ClientLevel{Price: xs[v.depth].Price, Quantity: decimal.Zero})
+	}
+
+	return changes
+}
+
+func stream(writer http.ResponseWriter, request *http.Request) {
+	exchange := getExchange(request)
+	defaultSymbol := getSymbol(request)
+
+	conn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		// The upgrader has responded already.
+		logf("WRN: Error while upgrading connection: %v\n", err)
+
+		return
+	}
+
+	c := &streamConnection{
+		conn:         conn,
+		mu:           sync.Mutex{},
+		streams:      make(map[string]*symbolStream),
+		streamsMutex: sync.Mutex{},
+	}
+	defer c.close()
+
+	for {
+		var r streamRequest
+		if err := conn.ReadJSON(&r); err != nil {
+			return
+		}
+
+		if r.Symbol == "" {
+			r.Symbol = defaultSymbol
+		}
+
+		var err error
+
+		switch r.Method {
+		case "subscribe":
+			err = c.subscribe(exchange, r)
+		case "unsubscribe":
+			c.unsubscribe(r.Symbol)
+		default:
+			err = errUnknownMethod
+		}
+
+		if err != nil {
+			c.sendError(r.Symbol, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package orderbook
+
+import (
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultDepthHistory is how many level changes a book keeps for diffs.
+const DefaultDepthHistory = 10000
+
+// Diff lists the levels that changed on each side of the book between
+// two sequence numbers, with their new quantities.  Zero quantity means
+// the level is gone.  Applying it on top of a snapshot with sequence
+// number From gives the book as of To.
+type Diff struct {
+	From uint64        `json:"from"`
+	To   uint64        `json:"to"`
+	Asks []ClientLevel `json:"asks"`
+	Bids []ClientLevel `json:"bids"`
+}
+
+type levelChange struct {
+	sequence uint64
+	key      levelKey
+	level    LevelUpdate
+}
+
+// WithDepthHistory sets how many level changes the book keeps for
+// diffs, see Diff.  It must be positive.
+func WithDepthHistory(n int) BookOption {
+	return func(b *Book) {
+		b.depthHistory = n
+	}
+}
+
+// recordLevel appends the level change of the last event to the log.
+// Once the log is full, its older half gets dropped.  The caller must
+// hold b.mu.
+func (b *Book) recordLevel(key levelKey, level LevelUpdate) {
+	if len(b.levelChanges) >= b.depthHistory {
+		n := len(b.levelChanges) - b.depthHistory/2
+		b.levelsSince = b.levelChanges[n-1].sequence
+		b.levelChanges = append(b.levelChanges[:0], b.levelChanges[n:]...)
+	}
+
+	b.levelChanges = append(b.levelChanges, levelChange{sequence: b.sequence, key: key, level: level})
+}
+
+// Diff returns the levels changed since the given sequence number, e.g.
+// the one of a snapshot or a previous diff.
+func (b *Book) Diff(since uint64) (Diff, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if since > b.sequence {
+		return Diff{}, ErrInvalidSequence
+	}
+
+	// The changes are not kept anymore, a new snapshot is needed.
+	if since < b.levelsSince {
+		return Diff{}, ErrSequenceTooOld
+	}
+
+	i := sort.Search(len(b.levelChanges), func(i int) bool {
+		return b.levelChanges[i].sequence > since
+	})
+
+	// Only the last change of each level matters.
+	asks := make(map[int64]ClientLevel)
+	bids := make(map[int64]ClientLevel)
+
+	for _, change := range b.levelChanges[i:] {
+		level := ClientLevel{Price: change.level.Price, Quantity: change.level.Quantity}
+
+		if change.key.side == SideSell {
+			asks[change.key.price] = level
+		} else {
+			bids[change.key.price] = level
+		}
+	}
+
+	return Diff{
+		From: since,
+		To:   b.sequence,
+		Asks: sortLevels(asks, decimal.Decimal.LessThan),
+		Bids: sortLevels(bids, decimal.Decimal.GreaterThan),
+	}, nil
+}
+
+func sortLevels(levels map[int64]ClientLevel, less func(a, b decimal.Decimal) bool) []ClientLevel {
+	ans := make([]ClientLevel, 0, len(levels))
+
+	for _, level := range levels {
+		ans = append(ans, level)
+	}
+
+	sort.Slice(ans, func(i, j int) bool {
+		return less(ans[i].Price, ans[j].Price)
+	})
+
+	return ans
+}
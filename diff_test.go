@@ -0,0 +1,91 @@
+package orderbook_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ydm/orderbook"
+)
+
+// apply applies the given level changes on top of the given levels.
+func apply(levels, changes []orderbook.ClientLevel) map[string]string {
+	ans := make(map[string]string)
+
+	for _, level := range levels {
+		ans[level.Price.String()] = level.Quantity.String()
+	}
+
+	for _, level := range changes {
+		if level.Quantity.IsZero() {
+			delete(ans, level.Price.String())
+		} else {
+			ans[level.Price.String()] = level.Quantity.String()
+		}
+	}
+
+	return ans
+}
+
+func TestBook_Diff(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	submit(t, b, limitOrder("sell100", orderbook.SideSell, 100, 2))
+	submit(t, b, limitOrder("sell101", orderbook.SideSell, 101, 2))
+	submit(t, b, limitOrder("buy99", orderbook.SideBuy, 99, 2))
+
+	before := b.GetSnapshot(10)
+
+	submit(t, b, limitOrder("buy100", orderbook.SideBuy, 100, 3))
+	submit(t, b, limitOrder("sell102", orderbook.SideSell, 102, 1))
+
+	if err := b.CancelOrder("buy99"); err != nil {
+		t.Error(err)
+	}
+
+	diff, err := b.Diff(before.Sequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := b.GetSnapshot(10)
+	if diff.From != before.Sequence || diff.To != after.Sequence {
+		t.Errorf("have %d-%d, want %d-%d", diff.From, diff.To, before.Sequence, after.Sequence)
+	}
+
+	if have, want := apply(before.Asks, diff.Asks), apply(after.Asks, nil); !reflect.DeepEqual(have, want) {
+		t.Errorf("have asks %v, want %v", have, want)
+	}
+
+	if have, want := apply(before.Bids, diff.Bids), apply(after.Bids, nil); !reflect.DeepEqual(have, want) {
+		t.Errorf("have bids %v, want %v", have, want)
+	}
+
+	// Nothing changed since the last diff.
+	if diff, err := b.Diff(diff.To); err != nil || len(diff.Asks) != 0 || len(diff.Bids) != 0 {
+		t.Errorf("unexpected diff %v, %v", diff, err)
+	}
+
+	if _, err := b.Diff(diff.To + 1); !errors.Is(err, orderbook.ErrInvalidSequence) {
+		t.Errorf("have %v, want ErrInvalidSequence", err)
+	}
+}
+
+// Books keep only so many level changes.
+func TestBook_Diff_History(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook(orderbook.WithDepthHistory(2))
+	submit(t, b, limitOrder("sell100", orderbook.SideSell, 100, 2))
+
+	before := b.GetSnapshot(10)
+
+	submit(t, b, limitOrder("sell101", orderbook.SideSell, 101, 2))
+	submit(t, b, limitOrder("sell102", orderbook.SideSell, 102, 2))
+	submit(t, b, limitOrder("sell103", orderbook.SideSell, 103, 2))
+
+	if _, err := b.Diff(before.Sequence); !errors.Is(err, orderbook.ErrSequenceTooOld) {
+		t.Errorf("have %v, want ErrSequenceTooOld", err)
+	}
+}
@@ -0,0 +1,338 @@
+package orderbook
+
+import (
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Event types.
+const (
+	EventOrderAccepted = iota
+	EventOrderRejected
+	EventOrderPartiallyFilled
+	EventOrderFilled
+	EventOrderCanceled
+	EventOrderExpired
+	EventOrderTriggered // A stop order reached its stop price.
+	EventOrderAmended
+	EventTrade
+	EventSelfTradePrevented
+	EventLevelChanged
+	EventGap // The subscription fell behind and got dropped, see WithEventQueueLimit.
+)
+
+// DefaultEventQueueLimit is how many undelivered events a subscription
+// may have before it gets dropped.
+const DefaultEventQueueLimit = 100000
+
+// orderEvents maps order states to the events reporting them.  Resting
+// and pending orders get reported as accepted.
+var orderEvents = map[int]int{ //nolint:gochecknoglobals
+	StateRejected:        EventOrderRejected,
+	StatePartiallyFilled: EventOrderPartiallyFilled,
+	StateFilled:          EventOrderFilled,
+	StateCanceled:        EventOrderCanceled,
+	StateExpired:         EventOrderExpired,
+}
+
+// Event describes a single change of the book.  Depending on its type,
+// exactly one of Order, Trade, Prevented and Level is set, except for
+// gaps, which carry the sequence number of the first event missed.
+type Event struct {
+	Type      int             `json:"type"`
+	Sequence  uint64          `json:"sequence"` // Book sequence number, one per event.
+	Symbol    string          `json:"symbol"`
+	Time      time.Time       `json:"time"`
+	Order     *ClientOrder    `json:"order,omitempty"`
+	Trade     *Trade          `json:"trade,omitempty"`
+	Prevented *PreventedMatch `json:"prevented,omitempty"`
+	Level     *LevelUpdate    `json:"level,omitempty"`
+}
+
+// LevelUpdate is the new visible quantity at a price level of the book.
+// Zero quantity means the level is gone.
+type LevelUpdate struct {
+	Side     int             `json:"side"` // SideBuy for bids, SideSell for asks.
+	Price    decimal.Decimal `json:"price"`
+	Quantity decimal.Decimal `json:"quantity"`
+}
+
+func newEvent(eventType int, now time.Time) Event {
+	return Event{
+		Type:      eventType,
+		Sequence:  0,
+		Symbol:    "",
+		Time:      now,
+		Order:     nil,
+		Trade:     nil,
+		Prevented: nil,
+		Level:     nil,
+	}
+}
+
+// Listener receives the events of a book in order.  It gets called from
+// a goroutine of its own, so a slow listener does not hold up the book,
+// it only falls behind.  Once it is too far behind, it gets a gap event
+// and no more events after it.
+type Listener func(event Event)
+
+// WithEventQueueLimit sets how many undelivered events a subscription
+// may have.  Once there are more, the rest get dropped along with the
+// subscription, so a stalled listener does not use up memory.  It must
+// be positive.
+func WithEventQueueLimit(n int) BookOption {
+	return func(b *Book) {
+		b.eventQueueLimit = n
+	}
+}
+
+// Subscription delivers the events of a book to a listener until it gets
+// closed.
+type Subscription struct {
+	book     *Book
+	listener Listener
+
+	queue   []Event
+	closed  bool
+	dropped bool // Ends with a gap, see push.
+	mu      sync.Mutex
+
+	wake chan struct{}
+	done chan struct{}
+}
+
+// Subscribe makes the given listener receive all events of the book from
+// now on.
+func (b *Book) Subscribe(listener Listener) *Subscription {
+	s := &Subscription{
+		book:     b,
+		listener: listener,
+		queue:    make([]Event, 0),
+		closed:   false,
+		dropped:  false,
+		mu:       sync.Mutex{},
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mu.Unlock()
+
+	go s.run()
+
+	return s
+}
+
+// Close stops delivering events.  Events not delivered yet are dropped.
+// It is safe to call Close from the listener.
+func (s *Subscription) Close() {
+	b := s.book
+
+	b.mu.Lock()
+	for i, x := range b.subscribers {
+		if x == s {
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		s.queue = nil
+
+		close(s.done)
+	}
+}
+
+// push queues the given event without blocking.  If the queue is full,
+// a gap gets queued instead and the subscription is dropped.  Returns
+// whether the subscription still takes events.
+func (s *Subscription) push(event Event, limit int) bool {
+	s.mu.Lock()
+
+	switch {
+	case s.closed, s.dropped:
+		// Nothing gets delivered anymore.
+	case len(s.queue) < limit:
+		s.queue = append(s.queue, event)
+	default:
+		gap := newEvent(EventGap, event.Time)
+		gap.Sequence = event.Sequence
+		gap.Symbol = event.Symbol
+
+		s.queue = append(s.queue, gap)
+		s.dropped = true
+	}
+
+	ok := !s.closed && !s.dropped
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return ok
+}
+
+func (s *Subscription) run() {
+	for {
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+
+		for {
+			s.mu.Lock()
+			events := s.queue
+			s.queue = make([]Event, 0)
+			dropped := s.dropped
+			s.mu.Unlock()
+
+			if len(events) == 0 {
+				if dropped {
+					// The gap was the last event.
+					return
+				}
+
+				break
+			}
+
+			for _, event := range events {
+				select {
+				case <-s.done:
+					return
+				default:
+					s.listener(event)
+				}
+			}
+		}
+	}
+}
+
+// emit numbers the given event and queues it for all subscribers.  Trades
+// and prevented matches carry the sequence number of their event.  The
+// caller must hold b.mu.
+func (b *Book) emit(event Event) {
+	b.sequence++
+
+	event.Sequence = b.sequence
+	event.Symbol = b.Symbol
+
+	if event.Trade != nil {
+		event.Trade.Sequence = b.sequence
+	}
+
+	if event.Prevented != nil {
+		event.Prevented.Sequence = b.sequence
+	}
+
+	// Dropped subscriptions get removed.
+	subscribers := b.subscribers[:0]
+
+	for _, s := range b.subscribers {
+		if s.push(event, b.eventQueueLimit) {
+			subscribers = append(subscribers, s)
+		}
+	}
+
+	b.subscribers = subscribers
+}
+
+// save writes the given order into the database and emits an event if
+// its state or executed quantity changed.  The caller must hold b.mu and
+// b.databaseMutex.
+func (b *Book) save(order ClientOrder, now time.Time) {
+	previous, ok := b.database[order.Handle]
+	b.database[order.Handle] = order
+	b.ids[order.ID] = order.Handle
+
+	if ok && previous.State == order.State && previous.ExecutedQuantity.Equal(order.ExecutedQuantity) {
+		return
+	}
+
+	if eventType, ok := orderEvents[order.State]; ok {
+		b.emitOrder(eventType, order, now)
+	}
+}
+
+// emitOrder emits an event of the given type about the given order.
+// Without subscribers, the order does not get copied for it.  The caller
+// must hold b.mu.
+func (b *Book) emitOrder(eventType int, order ClientOrder, now time.Time) {
+	event := newEvent(eventType, now)
+
+	if len(b.subscribers) > 0 {
+		copied := order
+		event.Order = &copied
+	}
+
+	b.emit(event)
+}
+
+type levelKey struct {
+	side  int
+	price int64
+}
+
+// touch remembers that the given level of the book changed, so a level
+// event gets emitted for it by emitLevels.  Stop ladders are not part of
+// the book depth and get ignored.  The caller must hold b.mu.
+func (b *Book) touch(ladder *Ladder, price int64) {
+	var side int
+
+	switch ladder {
+	case &b.Asks:
+		side = SideSell
+	case &b.Bids:
+		side = SideBuy
+	default:
+		return
+	}
+
+	key := levelKey{side: side, price: price}
+	if _, ok := b.touched[key]; ok {
+		return
+	}
+
+	b.touched[key] = struct{}{}
+	b.touchedLevels = append(b.touchedLevels, key)
+}
+
+// emitLevels emits an event with the new quantity of each level touched
+// since the last call.  The caller must hold b.mu.
+func (b *Book) emitLevels(now time.Time) {
+	for _, key := range b.touchedLevels {
+		ladder := &b.Asks
+		if key.side == SideBuy {
+			ladder = &b.Bids
+		}
+
+		level := LevelUpdate{
+			Side:     key.side,
+			Price:    b.Instrument.Price(key.price),
+			Quantity: b.Instrument.Quantity(ladder.TotalQuantity(key.price)),
+		}
+
+		event := newEvent(EventLevelChanged, now)
+		event.Level = &level
+		b.emit(event)
+
+		b.recordLevel(key, level)
+	}
+
+	for key := range b.touched {
+		delete(b.touched, key)
+	}
+
+	b.touchedLevels = b.touchedLevels[:0]
+}
@@ -0,0 +1,139 @@
+package orderbook_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+func receive(t *testing.T, events <-chan orderbook.Event, n int) []orderbook.Event {
+	t.Helper()
+
+	ans := make([]orderbook.Event, 0, n)
+
+	for len(ans) < n {
+		select {
+		case event := <-events:
+			ans = append(ans, event)
+		case <-time.After(time.Second):
+			t.Fatalf("have %d events, want %d", len(ans), n)
+		}
+	}
+
+	return ans
+}
+
+func TestBook_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	events := make(chan orderbook.Event, 16)
+	subscription := b.Subscribe(func(event orderbook.Event) { events <- event })
+
+	submit(t, b, limitOrder("maker", orderbook.SideSell, 100, 2))
+	submit(t, b, limitOrder("taker", orderbook.SideBuy, 100, 1))
+
+	if err := b.CancelOrder("maker"); err != nil {
+		t.Error(err)
+	}
+
+	expected := []int{
+		orderbook.EventOrderAccepted,
+		orderbook.EventLevelChanged,
+		orderbook.EventOrderAccepted,
+		orderbook.EventTrade,
+		orderbook.EventOrderFilled,
+		orderbook.EventOrderPartiallyFilled,
+		orderbook.EventLevelChanged,
+		orderbook.EventOrderCanceled,
+		orderbook.EventLevelChanged,
+	}
+
+	have := receive(t, events, len(expected))
+
+	for i, event := range have {
+		if event.Type != expected[i] {
+			t.Errorf("event %d: have type %d, want %d", i, event.Type, expected[i])
+		}
+
+		if event.Sequence != uint64(i+1) {
+			t.Errorf("event %d: have sequence %d, want %d", i, event.Sequence, i+1)
+		}
+	}
+
+	if trade := have[3].Trade; trade == nil || trade.Sequence != have[3].Sequence {
+		t.Errorf("unexpected trade %v", trade)
+	}
+
+	for i, quantity := range map[int]int64{1: 2, 6: 1, 8: 0} {
+		if level := have[i].Level; level == nil || !level.Quantity.Equal(decimal.NewFromInt(quantity)) {
+			t.Errorf("event %d: unexpected level %v", i, level)
+		}
+	}
+
+	// Closed subscriptions do not get any more events.  Once another
+	// subscription gets them, they had their chance to arrive.
+	subscription.Close()
+
+	witness := make(chan orderbook.Event, 16)
+	defer b.Subscribe(func(event orderbook.Event) { witness <- event }).Close()
+
+	submit(t, b, limitOrder("another", orderbook.SideSell, 100, 2))
+	receive(t, witness, 2)
+
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %v after close", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// A stalled listener gets dropped with a gap once its queue is full and
+// does not hold up other listeners.
+func TestBook_Subscribe_Gap(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook(orderbook.WithEventQueueLimit(3))
+	release := make(chan struct{})
+	events := make(chan orderbook.Event, 64)
+
+	b.Subscribe(func(event orderbook.Event) {
+		<-release
+		events <- event
+	})
+
+	others := make(chan orderbook.Event, 64)
+	defer b.Subscribe(func(event orderbook.Event) { others <- event }).Close()
+
+	for price := int64(100); price < 110; price++ {
+		submit(t, b, limitOrder(fmt.Sprint(price), orderbook.SideSell, price, 1))
+		receive(t, others, 2)
+	}
+
+	close(release)
+
+	// Events arrive in order up to the gap, which tells the first one
+	// missed.
+	for i := uint64(1); ; i++ {
+		event := receive(t, events, 1)[0]
+		if event.Sequence != i {
+			t.Fatalf("have sequence %d, want %d", event.Sequence, i)
+		}
+
+		if event.Type == orderbook.EventGap {
+			break
+		}
+	}
+
+	submit(t, b, limitOrder("another", orderbook.SideSell, 110, 1))
+	receive(t, others, 2)
+
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %v after gap", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,210 @@
+package orderbook
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// Exchange keeps a registry of books, one per symbol, and routes orders
+// to them.  Order IDs are unique across the whole exchange, so an order
+// can be canceled or queried by its ID alone.  So are order handles,
+// see ClientOrder.Handle.
+type Exchange struct {
+	books map[string]*Book
+
+	// orders maps order ID to the symbol of the book that holds it, and
+	// handles does the same for order handles.
+	orders  map[string]string
+	handles map[uint64]string
+
+	// lastHandle is shared by all books, see Book.lastHandle.
+	lastHandle uint64
+
+	mu sync.RWMutex
+}
+
+func NewExchange() *Exchange {
+	return &Exchange{
+		books:      make(map[string]*Book),
+		orders:     make(map[string]string),
+		handles:    make(map[uint64]string),
+		lastHandle: 0,
+		mu:         sync.RWMutex{},
+	}
+}
+
+// AddBook creates a new book for the given symbol.
+func (e *Exchange) AddBook(symbol string, options ...BookOption) (*Book, error) {
+	if symbol == "" {
+		return nil, ErrInvalidSymbol
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.books[symbol]; ok {
+		return nil, ErrSymbolExists
+	}
+
+	// The symbol and handle options go last so they cannot be
+	// overridden.
+	book := NewBook(append(options, WithSymbol(symbol), withHandles(&e.lastHandle))...)
+	e.books[symbol] = book
+
+	return book, nil
+}
+
+// Book returns the book for the given symbol.
+func (e *Exchange) Book(symbol string) (*Book, error) {
+	e.mu.RLock()
+	book, ok := e.books[symbol]
+	e.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownSymbol
+	}
+
+	return book, nil
+}
+
+// Symbols returns all symbols traded on the exchange, sorted.
+func (e *Exchange) Symbols() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	ans := make([]string, 0, len(e.books))
+	for symbol := range e.books {
+		ans = append(ans, symbol)
+	}
+
+	sort.Strings(ans)
+
+	return ans
+}
+
+// AddOrder routes the order to the book of its symbol.
+func (e *Exchange) AddOrder(order ClientOrder) (Report, error) {
+	book, err := e.Book(order.Symbol)
+	if err != nil {
+		return Report{}, err
+	}
+
+	if order.ID == "" {
+		return Report{}, ErrInvalidID
+	}
+
+	// Reserve the order ID, so no other book can take it meanwhile.
+	e.mu.Lock()
+	if _, ok := e.orders[order.ID]; ok {
+		e.mu.Unlock()
+
+		return Report{}, ErrOrderExists
+	}
+
+	e.orders[order.ID] = order.Symbol
+	e.mu.Unlock()
+
+	report, err := book.AddOrder(order)
+
+	e.mu.Lock()
+	if report.Order.ID == "" {
+		// The book did not record the order, so release its ID.
+		delete(e.orders, order.ID)
+	} else {
+		e.handles[report.Order.Handle] = order.Symbol
+	}
+	e.mu.Unlock()
+
+	return report, err
+}
+
+// Simulate estimates what the order would execute in the book of its
+// symbol, without executing anything.
+func (e *Exchange) Simulate(order ClientOrder) (Simulation, error) {
+	book, err := e.Book(order.Symbol)
+	if err != nil {
+		return Simulation{}, err
+	}
+
+	return book.Simulate(order)
+}
+
+// CancelOrder cancels the order with the given ID, whichever book it is
+// in.
+func (e *Exchange) CancelOrder(id string) error {
+	book, err := e.bookOf(id)
+	if err != nil {
+		return err
+	}
+
+	return book.CancelOrder(id)
+}
+
+// AmendOrder changes the price and/or quantity of the order with the
+// given ID, whichever book it is in.
+func (e *Exchange) AmendOrder(id string, price, quantity decimal.Decimal) (Report, error) {
+	book, err := e.bookOf(id)
+	if err != nil {
+		return Report{}, err
+	}
+
+	return book.AmendOrder(id, price, quantity)
+}
+
+// GetOrder returns the order with the given ID, whichever book it is in.
+func (e *Exchange) GetOrder(id string) (ClientOrder, error) {
+	book, err := e.bookOf(id)
+	if err != nil {
+		return ClientOrder{}, err
+	}
+
+	return book.GetOrder(id)
+}
+
+// GetOrderByHandle returns the order with the given handle, whichever
+// book it is in.
+func (e *Exchange) GetOrderByHandle(handle uint64) (ClientOrder, error) {
+	e.mu.RLock()
+	symbol, ok := e.handles[handle]
+	e.mu.RUnlock()
+
+	if !ok {
+		return ClientOrder{}, ErrOrderDoesNotExist
+	}
+
+	book, err := e.Book(symbol)
+	if err != nil {
+		return ClientOrder{}, err
+	}
+
+	return book.GetOrderByHandle(handle)
+}
+
+// QueuePosition returns the queue position of the resting order with
+// the given ID, whichever book it is in.
+func (e *Exchange) QueuePosition(id string) (QueuePosition, error) {
+	book, err := e.bookOf(id)
+	if err != nil {
+		return QueuePosition{}, err
+	}
+
+	return book.QueuePosition(id)
+}
+
+func (e *Exchange) bookOf(id string) (*Book, error) {
+	if id == "" {
+		return nil, ErrInvalidID
+	}
+
+	e.mu.RLock()
+	symbol, ok := e.orders[id]
+	e.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrOrderDoesNotExist
+	}
+
+	return e.Book(symbol)
+}
@@ -0,0 +1,181 @@
+package orderbook_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+func newExchange(t *testing.T, symbols ...string) *orderbook.Exchange {
+	t.Helper()
+
+	e := orderbook.NewExchange()
+
+	for _, symbol := range symbols {
+		if _, err := e.AddBook(symbol); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return e
+}
+
+func TestExchange_AddBook(t *testing.T) {
+	t.Parallel()
+
+	e := newExchange(t, "ETHUSDT", "BTCUSDT")
+
+	if _, err := e.AddBook("BTCUSDT"); !errors.Is(err, orderbook.ErrSymbolExists) {
+		t.Errorf("have %v, want ErrSymbolExists", err)
+	}
+
+	if _, err := e.AddBook(""); !errors.Is(err, orderbook.ErrInvalidSymbol) {
+		t.Errorf("have %v, want ErrInvalidSymbol", err)
+	}
+
+	if have, want := e.Symbols(), []string{"BTCUSDT", "ETHUSDT"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+
+	if _, err := e.Book("XRPUSDT"); !errors.Is(err, orderbook.ErrUnknownSymbol) {
+		t.Errorf("have %v, want ErrUnknownSymbol", err)
+	}
+}
+
+// Orders get routed to the book of their symbol and their IDs are unique
+// across all books.
+func TestExchange_AddOrder(t *testing.T) {
+	t.Parallel()
+
+	e := newExchange(t, "BTCUSDT", "ETHUSDT")
+	order := orderbook.ClientOrder{
+		Symbol:           "BTCUSDT",
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(100),
+		ID:               "id1",
+		Type:             orderbook.TypeLimit,
+	}
+
+	if _, err := e.AddOrder(order); err != nil {
+		t.Error(err)
+	}
+
+	order.Symbol = "ETHUSDT"
+	if _, err := e.AddOrder(order); !errors.Is(err, orderbook.ErrOrderExists) {
+		t.Errorf("have %v, want ErrOrderExists", err)
+	}
+
+	order.ID = "id2"
+	if _, err := e.AddOrder(order); err != nil {
+		t.Error(err)
+	}
+
+	order.Symbol = "XRPUSDT"
+	order.ID = "id3"
+
+	if _, err := e.AddOrder(order); !errors.Is(err, orderbook.ErrUnknownSymbol) {
+		t.Errorf("have %v, want ErrUnknownSymbol", err)
+	}
+
+	for id, symbol := range map[string]string{"id1": "BTCUSDT", "id2": "ETHUSDT"} {
+		have, err := e.GetOrder(id)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if have.Symbol != symbol {
+			t.Errorf("order %s: have symbol %s, want %s", id, have.Symbol, symbol)
+		}
+
+		book, err := e.Book(symbol)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertCountLevels(t, book, 0, 1)
+	}
+
+	if _, err := e.GetOrder("id3"); !errors.Is(err, orderbook.ErrOrderDoesNotExist) {
+		t.Errorf("have %v, want ErrOrderDoesNotExist", err)
+	}
+}
+
+// Orders get canceled by ID alone.
+func TestExchange_CancelOrder(t *testing.T) {
+	t.Parallel()
+
+	e := newExchange(t, "BTCUSDT", "ETHUSDT")
+
+	if _, err := e.AddOrder(orderbook.ClientOrder{
+		Symbol:           "ETHUSDT",
+		Side:             orderbook.SideSell,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(100),
+		ID:               "id1",
+		Type:             orderbook.TypeLimit,
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if err := e.CancelOrder("id1"); err != nil {
+		t.Error(err)
+	}
+
+	if err := e.CancelOrder("id1"); !errors.Is(err, orderbook.ErrOrderAlreadyCanceled) {
+		t.Errorf("have %v, want ErrOrderAlreadyCanceled", err)
+	}
+
+	if err := e.CancelOrder("id2"); !errors.Is(err, orderbook.ErrOrderDoesNotExist) {
+		t.Errorf("have %v, want ErrOrderDoesNotExist", err)
+	}
+
+	book, err := e.Book("ETHUSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertCountLevels(t, book, 0, 0)
+}
+
+// Handles are unique across all books, so orders may be queried by
+// their handle alone.
+func TestExchange_GetOrderByHandle(t *testing.T) {
+	t.Parallel()
+
+	e := newExchange(t, "BTCUSDT", "ETHUSDT")
+
+	for _, symbol := range []string{"BTCUSDT", "ETHUSDT"} {
+		if _, err := e.AddOrder(orderbook.ClientOrder{
+			Symbol:           symbol,
+			Side:             orderbook.SideSell,
+			OriginalQuantity: decimal.NewFromInt(1),
+			ExecutedQuantity: decimal.Zero,
+			Price:            decimal.NewFromInt(100),
+			ID:               "id-" + symbol,
+			Type:             orderbook.TypeLimit,
+		}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for handle, symbol := range map[uint64]string{1: "BTCUSDT", 2: "ETHUSDT"} {
+		have, err := e.GetOrderByHandle(handle)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if have.Symbol != symbol || have.Handle != handle {
+			t.Errorf("have %v, want handle %d in %s", have, handle, symbol)
+		}
+	}
+
+	if _, err := e.GetOrderByHandle(3); !errors.Is(err, orderbook.ErrOrderDoesNotExist) {
+		t.Errorf("have %v, want ErrOrderDoesNotExist", err)
+	}
+}
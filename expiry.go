@@ -0,0 +1,114 @@
+package orderbook
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiry is a scheduled expiration of a resting GTD or DAY order.
+type expiry struct {
+	time   time.Time
+	handle uint64
+}
+
+// expiryHeap keeps expirations ordered by time, the earliest one on top.
+// Entries of orders that got canceled, filled or rescheduled meanwhile
+// are not removed, they get skipped once they reach the top, see
+// Book.expireOrders.
+type expiryHeap []expiry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].time.Before(h[j].time)
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e, ok := x.(expiry)
+	if !ok {
+		panic("")
+	}
+
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	n := len(*h)
+	e := (*h)[n-1]
+	*h = (*h)[:n-1]
+
+	return e
+}
+
+// scheduleExpiry makes the order with the given handle expire at the
+// given time.  The caller must hold b.mu.
+func (b *Book) scheduleExpiry(handle uint64, expireTime time.Time) {
+	if current, ok := b.expiries[handle]; ok && current.Equal(expireTime) {
+		return
+	}
+
+	b.expiries[handle] = expireTime
+	heap.Push(&b.expiryQueue, expiry{time: expireTime, handle: handle})
+}
+
+// unscheduleExpiry cancels the expiration of the order with the given
+// handle, if it has one.  The caller must hold b.mu.
+func (b *Book) unscheduleExpiry(handle uint64) {
+	if _, ok := b.expiries[handle]; !ok {
+		return
+	}
+
+	delete(b.expiries, handle)
+
+	// Skipped entries pile up while their time has not come yet, so
+	// drop them all once they make up most of the queue.
+	const minCompaction = 64
+
+	if n := len(b.expiryQueue); n >= minCompaction && n > 2*len(b.expiries) {
+		b.expiryQueue = b.expiryQueue[:0]
+		for handle, expireTime := range b.expiries {
+			b.expiryQueue = append(b.expiryQueue, expiry{time: expireTime, handle: handle})
+		}
+
+		heap.Init(&b.expiryQueue)
+	}
+}
+
+// unexpired returns a live function of Ladder.sweep, which skips the
+// orders expiring at or before now, or nil if there are none.  The
+// caller must hold b.mu.
+func (b *Book) unexpired(now time.Time) func(maker *Order) bool {
+	if len(b.expiryQueue) == 0 || now.Before(b.expiryQueue[0].time) {
+		return nil
+	}
+
+	return func(maker *Order) bool {
+		expireTime, ok := b.expiries[maker.Handle]
+
+		return !ok || now.Before(expireTime)
+	}
+}
+
+// nextExpired pops the earliest scheduled expiration at or before now.
+// The caller must hold b.mu.
+func (b *Book) nextExpired(now time.Time) (uint64, bool) {
+	for len(b.expiryQueue) > 0 && !now.Before(b.expiryQueue[0].time) {
+		e, ok := heap.Pop(&b.expiryQueue).(expiry)
+		if !ok {
+			panic("illegal state")
+		}
+
+		// The order may have been unscheduled or rescheduled since.
+		if expireTime, ok := b.expiries[e.handle]; ok && expireTime.Equal(e.time) {
+			delete(b.expiries, e.handle)
+
+			return e.handle, true
+		}
+	}
+
+	return 0, false
+}
@@ -6,3 +6,5 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/shopspring/decimal v1.3.1
 )
+
+require github.com/gorilla/websocket v1.5.0
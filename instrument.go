@@ -0,0 +1,237 @@
+package orderbook
+
+import (
+	"math"
+
+	"github.com/shopspring/decimal"
+)
+
+// MaxPrecision is the maximum number of decimal places of prices and
+// quantities.
+const MaxPrecision = 8
+
+// MaxUnits bounds prices in ticks and quantities in lots, see
+// Instrument.Ticks and Instrument.Lots.  It leaves room for summing up
+// over a thousand of them without overflowing an int64.
+const MaxUnits = 1 << 53
+
+// Instrument specifies which prices and quantities a book accepts.
+// Zero limits (MinQuantity, MaxQuantity and MinNotional) are not
+// enforced.
+//
+// Internally, books keep prices as whole numbers of ticks and quantities
+// as whole numbers of lots, so matching does not need decimal arithmetic.
+type Instrument struct {
+	TickSize          decimal.Decimal `json:"tickSize"`          // Prices must be multiples of it.
+	LotSize           decimal.Decimal `json:"lotSize"`           // Quantities must be multiples of it.
+	PricePrecision    int32           `json:"pricePrecision"`    // Max number of decimal places.
+	QuantityPrecision int32           `json:"quantityPrecision"` // Max number of decimal places.
+	MinQuantity       decimal.Decimal `json:"minQuantity"`
+	MaxQuantity       decimal.Decimal `json:"maxQuantity"`
+	MinNotional       decimal.Decimal `json:"minNotional"` // Min price * quantity.
+}
+
+// DefaultInstrument accepts any positive price and quantity with up to
+// MaxPrecision decimal places.
+func DefaultInstrument() Instrument {
+	unit := decimal.New(1, -MaxPrecision)
+
+	return Instrument{
+		TickSize:          unit,
+		LotSize:           unit,
+		PricePrecision:    MaxPrecision,
+		QuantityPrecision: MaxPrecision,
+		MinQuantity:       decimal.Zero,
+		MaxQuantity:       decimal.Zero,
+		MinNotional:       decimal.Zero,
+	}
+}
+
+// Validate makes sure the instrument specification is consistent.
+func (i Instrument) Validate() error {
+	if i.PricePrecision < 0 || i.PricePrecision > MaxPrecision ||
+		i.QuantityPrecision < 0 || i.QuantityPrecision > MaxPrecision {
+		return ErrInvalidInstrument
+	}
+
+	// Tick and lot sizes must be representable with the given precision.
+	if !i.TickSize.IsPositive() || !isRounded(i.TickSize, i.PricePrecision) ||
+		!i.LotSize.IsPositive() || !isRounded(i.LotSize, i.QuantityPrecision) {
+		return ErrInvalidInstrument
+	}
+
+	if i.MinQuantity.IsNegative() || i.MaxQuantity.IsNegative() || i.MinNotional.IsNegative() {
+		return ErrInvalidInstrument
+	}
+
+	if i.MaxQuantity.IsPositive() && i.MaxQuantity.LessThan(i.MinQuantity) {
+		return ErrInvalidInstrument
+	}
+
+	return nil
+}
+
+// CheckPrice makes sure the given limit price is on the tick and not
+// too large to be kept in ticks.
+func (i Instrument) CheckPrice(price decimal.Decimal) error {
+	_, err := i.checkPrice(price)
+
+	return err
+}
+
+// checkPrice is like CheckPrice, but also returns the price in ticks.
+func (i Instrument) checkPrice(price decimal.Decimal) (int64, error) {
+	if !isRounded(price, i.PricePrecision) {
+		return 0, ErrPricePrecision
+	}
+
+	return i.Ticks(price)
+}
+
+// CheckQuantity makes sure the given quantity is a whole number of lots
+// within the quantity limits.
+func (i Instrument) CheckQuantity(quantity decimal.Decimal) error {
+	_, err := i.checkQuantity(quantity)
+
+	return err
+}
+
+// checkQuantity is like CheckQuantity, but also returns the quantity in
+// lots.
+func (i Instrument) checkQuantity(quantity decimal.Decimal) (int64, error) {
+	if !isRounded(quantity, i.QuantityPrecision) {
+		return 0, ErrQuantityPrecision
+	}
+
+	lots, err := i.Lots(quantity)
+	if err != nil {
+		return 0, err
+	}
+
+	if quantity.IsNegative() || (i.MinQuantity.IsPositive() && quantity.LessThan(i.MinQuantity)) {
+		return 0, ErrQuantityTooSmall
+	}
+
+	if i.MaxQuantity.IsPositive() && quantity.GreaterThan(i.MaxQuantity) {
+		return 0, ErrQuantityTooLarge
+	}
+
+	return lots, nil
+}
+
+// CheckNotional makes sure the value of an order is large enough.
+func (i Instrument) CheckNotional(price, quantity decimal.Decimal) error {
+	// Without a minimum, only negative values are too small.
+	if i.MinNotional.IsZero() && price.Sign()*quantity.Sign() >= 0 {
+		return nil
+	}
+
+	if price.Mul(quantity).LessThan(i.MinNotional) {
+		return ErrNotionalTooSmall
+	}
+
+	return nil
+}
+
+// Ticks converts the given price into a whole number of ticks.
+func (i Instrument) Ticks(price decimal.Decimal) (int64, error) {
+	return units(price, i.TickSize, ErrPriceOffTick, ErrPriceOverflow)
+}
+
+// Price converts the given number of ticks back into a price.
+func (i Instrument) Price(ticks int64) decimal.Decimal {
+	return decimal.NewFromInt(ticks).Mul(i.TickSize)
+}
+
+// Lots converts the given quantity into a whole number of lots.
+func (i Instrument) Lots(quantity decimal.Decimal) (int64, error) {
+	return units(quantity, i.LotSize, ErrQuantityOffLot, ErrQuantityOverflow)
+}
+
+// Quantity converts the given number of lots back into a quantity.
+func (i Instrument) Quantity(lots int64) decimal.Decimal {
+	return decimal.NewFromInt(lots).Mul(i.LotSize)
+}
+
+// lotsDown converts the given quantity into lots, rounding down and
+// capping at MaxUnits.
+func (i Instrument) lotsDown(quantity decimal.Decimal) int64 {
+	lots := quantity.Div(i.LotSize).Floor()
+	if lots.GreaterThan(decimal.NewFromInt(MaxUnits)) {
+		return MaxUnits
+	}
+
+	return lots.IntPart()
+}
+
+// units divides d into a whole number of units of the given size, no
+// more than MaxUnits of them.
+func units(d, size decimal.Decimal, errInexact, errOverflow error) (int64, error) {
+	if n, exact, ok := divideSmall(d, size); ok {
+		switch {
+		case !exact:
+			return 0, errInexact
+		case n > MaxUnits || n < -MaxUnits:
+			return 0, errOverflow
+		default:
+			return n, nil
+		}
+	}
+
+	n, r := d.QuoRem(size, 0)
+
+	if !r.IsZero() {
+		return 0, errInexact
+	}
+
+	if n.Abs().GreaterThan(decimal.NewFromInt(MaxUnits)) {
+		return 0, errOverflow
+	}
+
+	return n.IntPart(), nil
+}
+
+// divideSmall divides d by the given positive size in 64-bit integers,
+// which is way faster than doing it in decimals.  Returns the quotient,
+// whether it is exact and whether d and size were small enough for it.
+func divideSmall(d, size decimal.Decimal) (int64, bool, bool) {
+	x, y := d.Coefficient(), size.Coefficient()
+	if !x.IsInt64() || !y.IsInt64() || y.Sign() <= 0 {
+		return 0, false, false
+	}
+
+	n, m := x.Int64(), y.Int64()
+
+	// Bring both to the smaller exponent.
+	var ok bool
+
+	if shift := int(d.Exponent()) - int(size.Exponent()); shift >= 0 {
+		n, ok = scaleInt64(n, shift)
+	} else {
+		m, ok = scaleInt64(m, -shift)
+	}
+
+	if !ok {
+		return 0, false, false
+	}
+
+	return n / m, n%m == 0, true
+}
+
+// scaleInt64 multiplies n by 10 to the given power, unless that
+// overflows.
+func scaleInt64(n int64, power int) (int64, bool) {
+	for ; power > 0; power-- {
+		if n > math.MaxInt64/10 || n < math.MinInt64/10 {
+			return 0, false
+		}
+
+		n *= 10
+	}
+
+	return n, true
+}
+
+func isRounded(d decimal.Decimal, places int32) bool {
+	return d.Equal(d.Truncate(places))
+}
@@ -0,0 +1,216 @@
+package orderbook_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+func newInstrument() orderbook.Instrument {
+	return orderbook.Instrument{
+		TickSize:          decimal.RequireFromString("0.05"),
+		LotSize:           decimal.RequireFromString("0.1"),
+		PricePrecision:    2,
+		QuantityPrecision: 1,
+		MinQuantity:       decimal.RequireFromString("0.2"),
+		MaxQuantity:       decimal.NewFromInt(100),
+		MinNotional:       decimal.NewFromInt(10),
+	}
+}
+
+func TestInstrument_Validate(t *testing.T) {
+	t.Parallel()
+
+	if err := orderbook.DefaultInstrument().Validate(); err != nil {
+		t.Error(err)
+	}
+
+	if err := newInstrument().Validate(); err != nil {
+		t.Error(err)
+	}
+
+	invalid := []func(i *orderbook.Instrument){
+		func(i *orderbook.Instrument) { i.TickSize = decimal.Zero },
+		func(i *orderbook.Instrument) { i.TickSize = decimal.RequireFromString("0.001") },
+		func(i *orderbook.Instrument) { i.LotSize = decimal.NewFromInt(-1) },
+		func(i *orderbook.Instrument) { i.PricePrecision = orderbook.MaxPrecision + 1 },
+		func(i *orderbook.Instrument) { i.QuantityPrecision = -1 },
+		func(i *orderbook.Instrument) { i.MaxQuantity = decimal.RequireFromString("0.1") },
+		func(i *orderbook.Instrument) { i.MinNotional = decimal.NewFromInt(-1) },
+	}
+
+	for index, modify := range invalid {
+		instrument := newInstrument()
+		modify(&instrument)
+
+		if err := instrument.Validate(); !errors.Is(err, orderbook.ErrInvalidInstrument) {
+			t.Errorf("case %d: have %v, want ErrInvalidInstrument", index, err)
+		}
+	}
+}
+
+func TestInstrument_Check(t *testing.T) {
+	t.Parallel()
+
+	instrument := newInstrument()
+
+	check := func(have, want error) {
+		t.Helper()
+
+		if !errors.Is(have, want) {
+			t.Errorf("have %v, want %v", have, want)
+		}
+	}
+
+	check(instrument.CheckPrice(decimal.RequireFromString("100.05")), nil)
+	check(instrument.CheckPrice(decimal.RequireFromString("100.04")), orderbook.ErrPriceOffTick)
+	check(instrument.CheckPrice(decimal.RequireFromString("100.051")), orderbook.ErrPricePrecision)
+
+	check(instrument.CheckQuantity(decimal.RequireFromString("0.2")), nil)
+	check(instrument.CheckQuantity(decimal.RequireFromString("0.25")), orderbook.ErrQuantityPrecision)
+	check(instrument.CheckQuantity(decimal.RequireFromString("0.1")), orderbook.ErrQuantityTooSmall)
+	check(instrument.CheckQuantity(decimal.RequireFromString("100.1")), orderbook.ErrQuantityTooLarge)
+
+	check(instrument.CheckNotional(decimal.NewFromInt(50), decimal.RequireFromString("0.2")), nil)
+	check(instrument.CheckNotional(decimal.NewFromInt(49), decimal.RequireFromString("0.2")), orderbook.ErrNotionalTooSmall)
+
+	// Lots don't have to be powers of ten.
+	instrument.LotSize = decimal.RequireFromString("0.5")
+	check(instrument.CheckQuantity(decimal.RequireFromString("1.5")), nil)
+	check(instrument.CheckQuantity(decimal.RequireFromString("1.2")), orderbook.ErrQuantityOffLot)
+}
+
+func TestInstrument_Ticks(t *testing.T) {
+	t.Parallel()
+
+	instrument := newInstrument()
+
+	ticks, err := instrument.Ticks(decimal.RequireFromString("100.05"))
+	if err != nil || ticks != 2001 {
+		t.Errorf("have %d %v, want 2001", ticks, err)
+	}
+
+	if have := instrument.Price(2001); !have.Equal(decimal.RequireFromString("100.05")) {
+		t.Errorf("have %v, want 100.05", have)
+	}
+
+	lots, err := instrument.Lots(decimal.RequireFromString("2.5"))
+	if err != nil || lots != 25 {
+		t.Errorf("have %d %v, want 25", lots, err)
+	}
+
+	if have := instrument.Quantity(25); !have.Equal(decimal.RequireFromString("2.5")) {
+		t.Errorf("have %v, want 2.5", have)
+	}
+
+	if _, err := instrument.Ticks(decimal.RequireFromString("100.04")); !errors.Is(err, orderbook.ErrPriceOffTick) {
+		t.Errorf("have %v, want ErrPriceOffTick", err)
+	}
+
+	if _, err := instrument.Lots(decimal.RequireFromString("2.55")); !errors.Is(err, orderbook.ErrQuantityOffLot) {
+		t.Errorf("have %v, want ErrQuantityOffLot", err)
+	}
+
+	// Just past MaxUnits does not fit anymore.
+	limit := decimal.NewFromInt(orderbook.MaxUnits)
+
+	if _, err := instrument.Ticks(limit.Mul(instrument.TickSize)); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := instrument.Ticks(limit.Add(decimal.NewFromInt(1)).Mul(instrument.TickSize)); !errors.Is(err,
+		orderbook.ErrPriceOverflow) {
+		t.Errorf("have %v, want ErrPriceOverflow", err)
+	}
+
+	if _, err := instrument.Lots(limit.Add(decimal.NewFromInt(1)).Mul(instrument.LotSize)); !errors.Is(err,
+		orderbook.ErrQuantityOverflow) {
+		t.Errorf("have %v, want ErrQuantityOverflow", err)
+	}
+}
+
+// Prices and quantities too large to be kept in ticks and lots get
+// rejected.
+func TestBook_AddOrder_Overflow(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	huge := decimal.New(1, 10)
+
+	order := limitOrder("id1", orderbook.SideSell, 1, 1)
+	order.Price = huge
+
+	if _, err := b.AddOrder(order); !errors.Is(err, orderbook.ErrPriceOverflow) {
+		t.Errorf("have %v, want ErrPriceOverflow", err)
+	}
+
+	order = limitOrder("id2", orderbook.SideSell, 1, 1)
+	order.OriginalQuantity = huge
+
+	if _, err := b.AddOrder(order); !errors.Is(err, orderbook.ErrQuantityOverflow) {
+		t.Errorf("have %v, want ErrQuantityOverflow", err)
+	}
+
+	order = limitOrder("id3", orderbook.SideSell, 1, 1)
+	order.DisplayQuantity = huge
+
+	if _, err := b.AddOrder(order); !errors.Is(err, orderbook.ErrInvalidDisplayQuantity) {
+		t.Errorf("have %v, want ErrInvalidDisplayQuantity", err)
+	}
+
+	assertCountLevels(t, b, 0, 0)
+}
+
+// Orders that would make the total quantity of a book side overflow get
+// rejected, even if each of them fits.
+func TestBook_AddOrder_LadderOverflow(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	largest := decimal.NewFromInt(orderbook.MaxUnits).Mul(orderbook.DefaultInstrument().LotSize)
+
+	sell := func(id string, quantity decimal.Decimal) orderbook.ClientOrder {
+		order := limitOrder(id, orderbook.SideSell, 100, 1)
+		order.OriginalQuantity = quantity
+
+		return order
+	}
+
+	// Room for 1023 of the largest orders, 1<<63 is one lot too many.
+	for i := 0; i < 1023; i++ {
+		submit(t, b, sell("sell"+strconv.Itoa(i), largest))
+	}
+
+	if _, err := b.AddOrder(sell("overflow", largest)); !errors.Is(err, orderbook.ErrLadderOverflow) {
+		t.Errorf("have %v, want ErrLadderOverflow", err)
+	}
+
+	// The other ladders are not affected.
+	submit(t, b, limitOrder("buy", orderbook.SideBuy, 99, 1))
+
+	stop := sell("stop", largest)
+	stop.Type = orderbook.TypeStopLimit
+	stop.StopPrice = decimal.NewFromInt(99)
+	submit(t, b, stop)
+
+	// Amending up must fit too.
+	lot := orderbook.DefaultInstrument().LotSize
+
+	if _, err := b.AmendOrder("sell0", decimal.Zero, lot); err != nil {
+		t.Error(err)
+	}
+
+	submit(t, b, sell("more", largest))
+
+	if _, err := b.AmendOrder("sell0", decimal.Zero, largest); !errors.Is(err, orderbook.ErrLadderOverflow) {
+		t.Errorf("have %v, want ErrLadderOverflow", err)
+	}
+
+	want := largest.Mul(decimal.NewFromInt(1023)).Add(lot)
+	if have := b.GetSnapshot(1).Asks[0].Quantity; !have.Equal(want) {
+		t.Errorf("have %v, want %v", have, want)
+	}
+}
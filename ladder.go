@@ -1,68 +1,143 @@
 package orderbook
 
 import (
-	"container/heap"
+	"fmt"
+	"math"
+	"strings"
 
 	"github.com/shopspring/decimal"
 )
 
-// Matches maps order ID to executed quantity.
-type Matches map[string]decimal.Decimal
+// Match is a single fill of a taker order against a maker order.
+// Prevented matches did not execute because both orders have the same
+// owner, see Order.SelfTrade.
+type Match struct {
+	MakerHandle uint64
+	Price       int64 // Maker's price, in ticks.
+	Quantity    int64 // Executed quantity, or the one that would have been, in lots.
+	Prevented   bool
+}
+
+// Matches lists fills in the order they happened.
+type Matches []Match
+
+// Fill is the quantity a taker order would match at a price level, see
+// Ladder.Sweep.
+type Fill struct {
+	Price    int64
+	Quantity int64
+}
 
 // Ladder keeps all price levels and their respective orders, allows
 // inspections and modifications.  It is either of type Ask or Bid.
+// Prices are in ticks and quantities in lots of the instrument, see
+// Instrument.Ticks and Instrument.Lots.
 type Ladder struct {
-	Heap    LevelHeap // Holds all levels in a convenient container.
-	Mapping LevelMap  // Maps price to level.
-	Type    int       // Ask or Bid.
+	Levels LadderBackend // Holds all levels ordered by price.
+	Type   int           // Ask or Bid.
+
+	// orders indexes the orders of all levels by handle, see
+	// OrderQueue.  Books share it between their ladders.
+	orders *orderIndex
+
+	quantity int64 // Total of all orders, see Fits.
 }
 
+// NewLadder creates a ladder that keeps its levels in a heap backend.
 func NewLadder(ladderType int) Ladder {
-	const heapSize = 256
+	return NewLadderWith(ladderType, NewHeapBackend)
+}
+
+// NewLadderWith creates a ladder that keeps its levels in a backend of
+// the given factory.
+func NewLadderWith(ladderType int, factory LadderBackendFactory) Ladder {
+	const indexSize = 256
 
 	return Ladder{
-		Heap:    make(LevelHeap, 0, heapSize),
-		Mapping: make(LevelMap),
-		Type:    ladderType,
+		Levels:   factory(ladderType),
+		Type:     ladderType,
+		orders:   newOrderIndex(indexSize),
+		quantity: 0,
 	}
 }
 
-func (d *Ladder) AddOrder(price decimal.Decimal, order Order) bool {
+// Len returns the number of levels.
+func (d *Ladder) Len() int {
+	return d.Levels.Len()
+}
+
+// Best returns the best level, or nil if the ladder is empty.
+func (d *Ladder) Best() *Level {
+	return d.Levels.Best()
+}
+
+// GetLevel returns the level of the given price.
+func (d *Ladder) GetLevel(price int64) (*Level, bool) {
+	return d.Levels.Get(price)
+}
+
+// Accepts reports whether orders of the given price can be added.
+func (d *Ladder) Accepts(price int64) bool {
+	return d.Levels.Accepts(price)
+}
+
+// Fits reports whether orders of the given total quantity can be added
+// without the quantity of the whole ladder exceeding math.MaxInt64.  As
+// long as it does not, summing up orders of the ladder cannot overflow.
+func (d *Ladder) Fits(quantity int64) bool {
+	return quantity <= math.MaxInt64-d.quantity
+}
+
+// AddOrder adds the given order to the level of the given price, unless
+// the order does not fit, see Fits.
+func (d *Ladder) AddOrder(price int64, order Order) bool {
+	// Handles are unique across all levels.
+	if _, ok := d.orders.orders[order.Handle]; ok || !d.Fits(order.Total()) {
+		return false
+	}
+
 	// First check if this level exists.
-	level, ok := d.Mapping[LevelMapKey(price)]
+	level, ok := d.Levels.Get(price)
 	if ok {
 		// Add the order to this existing level.
-		return level.Orders.Add(order)
-	}
+		if !level.Orders.Add(order) {
+			return false
+		}
+	} else {
+		if !d.Levels.Accepts(price) {
+			return false
+		}
 
-	// Level does not exist.  Create it and add the order.
-	level = NewLevel(price, d.Type)
-	if !level.Orders.Add(order) {
-		panic("illegal state")
+		// Level does not exist.  Create it and add the order.
+		level = newLevel(price, d.Type, d.orders)
+		if !level.Orders.Add(order) {
+			panic("illegal state")
+		}
+
+		// Save the newly made level into the backend.
+		d.Levels.Insert(level)
 	}
 
-	// Save the newly made level into our heap and mapping.
-	d.Mapping[LevelMapKey(price)] = level
-	heap.Push(&d.Heap, level)
+	d.quantity += order.Total()
 
 	return true
 }
 
-func (d *Ladder) RemoveOrder(price decimal.Decimal, id string) bool {
+func (d *Ladder) RemoveOrder(price int64, handle uint64) bool {
 	// Check if this level exists.
-	level, ok := d.Mapping[LevelMapKey(price)]
+	level, ok := d.Levels.Get(price)
 	if ok {
-		// Remove the order by its ID.
-		ans := level.Orders.RemoveByID(id)
+		if order := level.Orders.find(handle); order != nil {
+			d.quantity -= order.Total()
+		}
+
+		// Remove the order by its handle.
+		ans := level.Orders.RemoveByID(handle)
 
 		// If at this point the level is empty, remove it from
 		// this Ladder.
 		if level.Orders.Len() <= 0 {
-			delete(d.Mapping, LevelMapKey(price))
-
-			if heap.Remove(&d.Heap, level.index) == nil {
-				panic("illegal state")
-			}
+			d.removeLevel(level)
 		}
 
 		return ans
@@ -71,95 +146,487 @@ func (d *Ladder) RemoveOrder(price decimal.Decimal, id string) bool {
 	return false
 }
 
-// MatchOrderLimit tries to match the given quantity at the given
-// price.  Returns the order quantity left unmatched.
-func (d *Ladder) MatchOrderLimit(price decimal.Decimal, taker Order) (decimal.Decimal, Matches) {
-	level, ok := d.Mapping[LevelMapKey(price)]
-	matches := make(Matches)
+// ReduceOrder takes the given quantity off a resting order without
+// changing its place in the queue.  The quantity must be less than what
+// is left of the order.
+func (d *Ladder) ReduceOrder(price int64, handle uint64, quantity int64) bool {
+	level, ok := d.Levels.Get(price)
+	if !ok {
+		return false
+	}
 
-	if ok {
-		remove := make([]*Order, 0, 2)
-
-		for _, maker := range level.Orders.Iter() {
-			if taker.Quantity.LessThanOrEqual(maker.Quantity) {
-				// Given order (taker) is fully executed against an order
-				// from the order book (maker), which gets partially
-				// executed.
-				matches[maker.ID] = taker.Quantity
-				maker.Quantity = maker.Quantity.Sub(taker.Quantity)
-				taker.Quantity = decimal.Zero
-
-				if maker.Quantity.LessThanOrEqual(decimal.Zero) {
-					remove = append(remove, maker)
-				}
+	order := level.Orders.find(handle)
+	if order == nil || quantity >= order.Total() {
+		return false
+	}
 
-				// fmt.Printf("[1] taker=%v maker=%v\n", taker, maker)
-				break
-			} else {
-				// Given order (taker) gets partially executed against an
-				// order from the order book (maker), which gets fully
-				// executed.
-				matches[maker.ID] = maker.Quantity
-				taker.Quantity = taker.Quantity.Sub(maker.Quantity)
-				maker.Quantity = decimal.Zero
-				remove = append(remove, maker)
-				// fmt.Printf("[2] taker=%v maker=%v\n", taker, maker)
-			}
+	order.reduce(quantity)
+	d.quantity -= quantity
+
+	return true
+}
+
+func (d *Ladder) removeLevel(level *Level) {
+	d.Levels.Remove(level)
+}
+
+// MatchLevel tries to match the given quantity against the orders
+// resting at exactly the given price.  Returns the order quantity left
+// unmatched.
+//
+// When the visible quantity of an iceberg order is consumed, it gets
+// replenished from the hidden one and the order goes to the back of the
+// queue, losing its time priority.
+//
+// A maker of the taker's own gets handled according to the taker's
+// self-trade prevention mode instead.  If the taker gets canceled, the
+// quantity left is zero.
+func (d *Ladder) MatchLevel(price int64, taker Order) (int64, Matches) {
+	level, ok := d.Levels.Get(price)
+	matches := make(Matches, 0, 1)
+
+	if !ok {
+		return taker.Quantity, matches
+	}
+
+	for taker.Quantity > 0 && level.Orders.Len() > 0 {
+		maker := level.Orders.Peek()
+
+		if taker.selfTrades(*maker) {
+			quantity := minInt64(taker.Quantity, maker.Total())
+			matches = append(matches, Match{MakerHandle: maker.Handle, Price: level.Price, Quantity: quantity, Prevented: true})
+
+			d.preventSelfTrade(level, &taker, maker, quantity)
+
+			continue
 		}
 
-		for _, order := range remove {
-			d.RemoveOrder(price, order.ID)
+		// Either the taker gets fully executed against the maker or the
+		// other way around.
+		quantity := minInt64(taker.Quantity, maker.Quantity)
+		matches = append(matches, Match{MakerHandle: maker.Handle, Price: level.Price, Quantity: quantity, Prevented: false})
+		maker.Quantity -= quantity
+		taker.Quantity -= quantity
+		d.quantity -= quantity
+
+		if maker.Quantity > 0 {
+			break
+		}
+
+		if filled := level.Orders.Remove(); filled.Hidden > 0 {
+			filled.replenish()
+			level.Orders.Add(filled)
 		}
 	}
 
+	// If at this point the level is empty, remove it from this Ladder.
+	if level.Orders.Len() <= 0 {
+		d.removeLevel(level)
+	}
+
 	return taker.Quantity, matches
 }
 
-func (d *Ladder) MatchOrderMarket(taker Order) (decimal.Decimal, Matches) {
-	matches := make(Matches)
+// preventSelfTrade cancels or decrements the taker and/or the maker at
+// the front of the level, according to the taker's self-trade
+// prevention mode.
+func (d *Ladder) preventSelfTrade(level *Level, taker, maker *Order, quantity int64) {
+	switch taker.SelfTrade {
+	case SelfTradeCancelNewest:
+		taker.Quantity = 0
+	case SelfTradeCancelOldest:
+		d.quantity -= level.Orders.Remove().Total()
+	case SelfTradeCancelBoth:
+		taker.Quantity = 0
+		d.quantity -= level.Orders.Remove().Total()
+	case SelfTradeDecrement:
+		taker.Quantity -= quantity
+		d.quantity -= quantity
+
+		if maker.reduce(quantity); maker.Total() <= 0 {
+			level.Orders.Remove()
+		}
+	default:
+		panic("illegal self-trade prevention mode")
+	}
+}
+
+// Crosses reports whether a taker order with the given limit price would
+// trade against the best level of this ladder.
+func (d *Ladder) Crosses(price int64) bool {
+	best := d.Levels.Best()
+
+	return best != nil && d.crosses(best, price)
+}
+
+// best returns the best level with an order for which live returns
+// true, or nil.  Without live, every order counts.
+func (d *Ladder) best(live func(maker *Order) bool) *Level {
+	if live == nil {
+		return d.Levels.Best()
+	}
+
+	var ans *Level
+
+	d.Walk(func(level *Level) bool {
+		for maker := level.Orders.Peek(); maker != nil; maker = maker.Next() {
+			if live(maker) {
+				ans = level
+
+				return false
+			}
+		}
+
+		return true
+	})
+
+	return ans
+}
+
+// crosses reports whether a taker order with the given limit price would
+// trade against the given level of this ladder.
+func (d *Ladder) crosses(level *Level, price int64) bool {
+	switch d.Type {
+	case Ask:
+		return level.Price <= price
+	case Bid:
+		return level.Price >= price
+	default:
+		panic("illegal type")
+	}
+}
+
+// MatchOrderLimit sweeps the ladder starting from the best level and
+// going towards the given limit price.  Each level is filled at its own
+// (maker's) price.  Returns the order quantity left unmatched.
+func (d *Ladder) MatchOrderLimit(price int64, taker Order) (int64, Matches) {
+	matches := make(Matches, 0, 1)
+
+	// While there is still quantity to be matched and the best level is
+	// within the limit.
+	for taker.Quantity > 0 && d.Crosses(price) {
+		q, xs := d.MatchLevel(d.Levels.Best().Price, taker)
+		taker.Quantity = q
+
+		matches = append(matches, xs...)
+	}
+
+	return taker.Quantity, matches
+}
+
+func (d *Ladder) MatchOrderMarket(taker Order) (int64, Matches) {
+	matches := make(Matches, 0, 1)
 
 	// While there is still quantity to be matched and the ladder is not empty.
-	for taker.Quantity.IsPositive() && d.Heap.Len() > 0 {
-		price := d.Heap[0].Price
-		q, xs := d.MatchOrderLimit(price, taker)
+	for taker.Quantity > 0 && d.Levels.Len() > 0 {
+		price := d.Levels.Best().Price
+		q, xs := d.MatchLevel(price, taker)
 		taker.Quantity = q
 
-		for k, v := range xs {
-			matches[k] = v
-		}
+		matches = append(matches, xs...)
 	}
 
 	return taker.Quantity, matches
 }
 
-func (d *Ladder) GetOrder(price decimal.Decimal, orderID string) (Order, bool) {
-	level, ok := d.Mapping[LevelMapKey(price)]
+// Available sums up the quantity the given taker order with a limit
+// price could match against, without modifying the ladder.  The walk
+// stops as soon as the taker's quantity is reached.
+func (d *Ladder) Available(price int64, taker Order) int64 {
+	var ans int64
+
+	d.sweep(d.within(price), taker, nil, func(fill Fill) { ans += fill.Quantity })
+
+	return ans
+}
+
+// AvailableMarket is like Available, but for market orders, which have
+// no limit price.
+func (d *Ladder) AvailableMarket(taker Order) int64 {
+	var ans int64
+
+	d.sweep(anyLevel, taker, nil, func(fill Fill) { ans += fill.Quantity })
+
+	return ans
+}
+
+// Sweep returns the quantity the given taker order with a limit price
+// would match at each level, best one first, without modifying the
+// ladder.
+func (d *Ladder) Sweep(price int64, taker Order) []Fill {
+	return d.fills(d.within(price), taker, nil)
+}
+
+// SweepMarket is like Sweep, but for market orders, which have no limit
+// price.
+func (d *Ladder) SweepMarket(taker Order) []Fill {
+	return d.fills(anyLevel, taker, nil)
+}
+
+func (d *Ladder) fills(crosses func(level *Level) bool, taker Order, live func(maker *Order) bool) []Fill {
+	ans := make([]Fill, 0, 1)
+
+	d.sweep(crosses, taker, live, func(fill Fill) { ans = append(ans, fill) })
+
+	return ans
+}
+
+// within returns a crosses function of sweep for the given limit price.
+func (d *Ladder) within(price int64) func(level *Level) bool {
+	return func(level *Level) bool {
+		return d.crosses(level, price)
+	}
+}
+
+// anyLevel is the crosses function of sweep for market orders.
+func anyLevel(_ *Level) bool {
+	return true
+}
+
+// sweep calls visit with the quantity the given taker order would match
+// at each level, best one first, as long as crosses says so.  Makers for
+// which live returns false are skipped, see Book.Simulate.  Without live,
+// no maker is skipped.
+func (d *Ladder) sweep(
+	crosses func(level *Level) bool, taker Order, live func(maker *Order) bool, visit func(fill Fill),
+) {
+	// Only self-trade prevention makes the order in which the makers get
+	// reached matter, see replay.
+	ordered := taker.Owner != "" && taker.SelfTrade != SelfTradeAllow
+
+	d.Walk(func(level *Level) bool {
+		if taker.Quantity <= 0 || !crosses(level) {
+			return false
+		}
+
+		var matched int64
+
+		if ordered && level.HiddenQuantity() > 0 {
+			matched, taker.Quantity = replay(level, taker, live)
+		} else {
+			matched, taker.Quantity = walkLevel(level, taker, live)
+		}
+
+		if matched > 0 {
+			visit(Fill{Price: level.Price, Quantity: matched})
+		}
+
+		return true
+	})
+}
+
+// walkLevel sums up what the given taker order would match at the given
+// level, taking each maker in whole, hidden quantity included.  Returns
+// the quantity matched and left.
+func walkLevel(level *Level, taker Order, live func(maker *Order) bool) (int64, int64) {
+	var matched int64
+
+	for maker := level.Orders.Peek(); maker != nil && taker.Quantity > 0; maker = maker.Next() {
+		if live != nil && !live(maker) {
+			continue
+		}
+
+		quantity := minInt64(taker.Quantity, maker.Total())
+
+		if !taker.selfTrades(*maker) {
+			matched += quantity
+			taker.Quantity -= quantity
+
+			continue
+		}
+
+		// Self-trade prevention either stops the taker or takes the
+		// maker out of the way.
+		switch taker.SelfTrade {
+		case SelfTradeCancelNewest, SelfTradeCancelBoth:
+			taker.Quantity = 0
+		case SelfTradeDecrement:
+			taker.Quantity -= quantity
+		}
+	}
+
+	return matched, taker.Quantity
+}
+
+// replay runs the loop of MatchLevel on copies of the makers of the given
+// level.  Unlike walkLevel, it sends replenished icebergs to the back of
+// the queue, so a maker of the taker's own behind one gets reached just
+// as early as in matching.  Returns the quantity matched and left.
+func replay(level *Level, taker Order, live func(maker *Order) bool) (int64, int64) {
+	queue := make([]Order, 0, level.Orders.Len())
+
+	for maker := level.Orders.Peek(); maker != nil; maker = maker.Next() {
+		if live == nil || live(maker) {
+			queue = append(queue, maker.detached())
+		}
+	}
+
+	var matched int64
+
+	for taker.Quantity > 0 && len(queue) > 0 {
+		maker := &queue[0]
+
+		if taker.selfTrades(*maker) {
+			quantity := minInt64(taker.Quantity, maker.Total())
+
+			switch taker.SelfTrade {
+			case SelfTradeCancelNewest, SelfTradeCancelBoth:
+				taker.Quantity = 0
+			case SelfTradeCancelOldest:
+				queue = queue[1:]
+			case SelfTradeDecrement:
+				taker.Quantity -= quantity
+
+				if maker.reduce(quantity); maker.Total() <= 0 {
+					queue = queue[1:]
+				}
+			}
+
+			continue
+		}
+
+		quantity := minInt64(taker.Quantity, maker.Quantity)
+		matched += quantity
+		maker.Quantity -= quantity
+		taker.Quantity -= quantity
+
+		if maker.Quantity > 0 {
+			break
+		}
+
+		if filled := queue[0]; filled.Hidden > 0 {
+			filled.replenish()
+			queue = append(queue, filled)
+		}
+
+		queue = queue[1:]
+	}
+
+	return matched, taker.Quantity
+}
+
+func sumFills(fills []Fill) int64 {
+	var ans int64
+
+	for _, fill := range fills {
+		ans += fill.Quantity
+	}
+
+	return ans
+}
+
+// QuoteQuantity converts the given quote quantity into lots by sweeping
+// the ladder from the best level towards the given limit price.
+// Whatever the ladder cannot absorb gets valued at the last price
+// reached.  The result is capped at MaxUnits.
+func (d *Ladder) QuoteQuantity(price int64, quote decimal.Decimal, instrument Instrument) int64 {
+	return d.quoteQuantity(d.within(price), quote, instrument, nil)
+}
+
+// QuoteQuantityMarket is like QuoteQuantity, but without a limit price.
+func (d *Ladder) QuoteQuantityMarket(quote decimal.Decimal, instrument Instrument) int64 {
+	return d.quoteQuantity(anyLevel, quote, instrument, nil)
+}
+
+// quoteQuantity skips makers for which live returns false, just like
+// sweep.
+func (d *Ladder) quoteQuantity(
+	crosses func(level *Level) bool, quote decimal.Decimal, instrument Instrument, live func(maker *Order) bool,
+) int64 {
+	var ans int64
+
+	last := decimal.Zero
+
+	d.Walk(func(level *Level) bool {
+		if !quote.IsPositive() || !crosses(level) {
+			return false
+		}
+
+		total := level.TotalQuantity() + level.HiddenQuantity()
+
+		if live != nil {
+			total = 0
+
+			for maker := level.Orders.Peek(); maker != nil; maker = maker.Next() {
+				if live(maker) {
+					total += maker.Total()
+				}
+			}
+
+			if total == 0 {
+				return true
+			}
+		}
+
+		last = instrument.Price(level.Price)
+
+		if cost := instrument.Quantity(total).Mul(last); cost.LessThanOrEqual(quote) {
+			ans += total
+			quote = quote.Sub(cost)
+
+			return true
+		}
+
+		ans += instrument.lotsDown(quote.Div(last))
+		quote = decimal.Zero
+
+		return false
+	})
+
+	if quote.IsPositive() && last.IsPositive() {
+		ans += instrument.lotsDown(quote.Div(last))
+	}
+
+	return minInt64(ans, MaxUnits)
+}
+
+func (d *Ladder) GetOrder(price int64, handle uint64) (Order, bool) {
+	level, ok := d.Levels.Get(price)
 
 	if ok {
-		return level.Orders.GetByID(orderID)
+		return level.Orders.GetByID(handle)
 	}
 
 	return Order{
-		ID:             orderID,
-		Quantity:       decimal.Zero,
+		Handle:         handle,
+		Quantity:       0,
 		InsertionIndex: 0,
+		Hidden:         0,
+		Display:        0,
+		Owner:          "",
+		SelfTrade:      SelfTradeCancelNewest,
+		prev:           nil,
+		next:           nil,
+		queue:          nil,
 	}, false
 }
 
-func (d *Ladder) TotalQuantity(price decimal.Decimal) decimal.Decimal {
-	level, ok := d.Mapping[LevelMapKey(price)]
+func (d *Ladder) TotalQuantity(price int64) int64 {
+	level, ok := d.Levels.Get(price)
 
 	if ok {
 		return level.TotalQuantity()
 	}
 
-	return decimal.Zero
+	return 0
 }
 
 func (d *Ladder) Walk(f func(level *Level) bool) {
-	d.Heap.Walk(f)
+	d.Levels.Walk(f)
 }
 
 func (d *Ladder) String() string {
-	return d.Heap.String()
+	var out strings.Builder
+
+	fmt.Fprintf(&out, "[Ladder \n")
+
+	d.Walk(func(level *Level) bool {
+		fmt.Fprintf(&out, "%v\n", level)
+
+		return true
+	})
+
+	fmt.Fprintf(&out, "]")
+
+	return out.String()
 }
@@ -3,30 +3,29 @@ package orderbook_test
 import (
 	"testing"
 
-	"github.com/shopspring/decimal"
 	"github.com/ydm/orderbook"
 )
 
-func assertMatches(t *testing.T, have orderbook.Matches, want map[string]string) {
+func assertMatches(t *testing.T, have orderbook.Matches, want map[uint64]int64) {
 	t.Helper()
 
 	if len(have) != len(want) {
 		t.Errorf("have %d, want %d", len(have), len(want))
 	}
 
-	for key, value := range want {
-		haveValue, ok := have[key]
+	byHandle := make(map[uint64]int64)
+	for _, match := range have {
+		byHandle[match.MakerHandle] = match.Quantity
+	}
+
+	for key, wantValue := range want {
+		haveValue, ok := byHandle[key]
 		if !ok {
 			t.Error()
 		}
 
-		wantValue, err := decimal.NewFromString(value)
-		if err != nil {
-			panic(err)
-		}
-
-		if !haveValue.Equal(wantValue) {
-			t.Errorf("have %v, want %v", haveValue, wantValue)
+		if haveValue != wantValue {
+			t.Errorf("have %d, want %d", haveValue, wantValue)
 		}
 	}
 }
@@ -43,21 +42,21 @@ func TestLadder_Walk_1(t *testing.T) {
 	}
 
 	ladder := orderbook.NewLadder(orderbook.Ask)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(4), orderbook.NewOrder("id1", decimal.NewFromFloat(0.1))), true)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(4), orderbook.NewOrder("id1", decimal.NewFromFloat(0.1))), false)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(4), orderbook.NewOrder("id1", decimal.NewFromFloat(0.1))), false)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(2), orderbook.NewOrder("id2", decimal.NewFromFloat(0.2))), true)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(5), orderbook.NewOrder("id3", decimal.NewFromFloat(0.3))), true)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(1), orderbook.NewOrder("id4", decimal.NewFromFloat(0.4))), true)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(3), orderbook.NewOrder("id5", decimal.NewFromFloat(0.5))), true)
-
-	expected := []string{"1", "2", "3", "4", "5"}
+	assertEq(ladder.AddOrder(4, orderbook.NewOrder(1, 1)), true)
+	assertEq(ladder.AddOrder(4, orderbook.NewOrder(1, 1)), false)
+	assertEq(ladder.AddOrder(4, orderbook.NewOrder(1, 1)), false)
+	assertEq(ladder.AddOrder(2, orderbook.NewOrder(2, 2)), true)
+	assertEq(ladder.AddOrder(5, orderbook.NewOrder(3, 3)), true)
+	assertEq(ladder.AddOrder(1, orderbook.NewOrder(4, 4)), true)
+	assertEq(ladder.AddOrder(3, orderbook.NewOrder(5, 5)), true)
+
+	expected := []int64{1, 2, 3, 4, 5}
 	index := 0
 
 	ladder.Walk(func(level *orderbook.Level) bool {
 		t.Helper()
-		if level.Price.String() != expected[index] {
-			t.Errorf("have %v, want %s", level.Price, expected[index])
+		if level.Price != expected[index] {
+			t.Errorf("have %d, want %d", level.Price, expected[index])
 		}
 		index++
 
@@ -77,22 +76,22 @@ func TestLadder_RemoveOrder(t *testing.T) {
 	}
 
 	ladder := orderbook.NewLadder(orderbook.Ask)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(4), orderbook.NewOrder("id1", decimal.NewFromFloat(0.1))), true)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(2), orderbook.NewOrder("id2", decimal.NewFromFloat(0.2))), true)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(5), orderbook.NewOrder("id3", decimal.NewFromFloat(0.3))), true)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(1), orderbook.NewOrder("id4", decimal.NewFromFloat(0.4))), true)
-	assertEq(ladder.AddOrder(decimal.NewFromInt(3), orderbook.NewOrder("id5", decimal.NewFromFloat(0.5))), true)
-	assertEq(ladder.RemoveOrder(decimal.NewFromInt(4), "id1"), true)
-	assertEq(ladder.RemoveOrder(decimal.NewFromInt(4), "id1"), false)
-
-	expected := []string{"1", "2", "3", "5"}
+	assertEq(ladder.AddOrder(4, orderbook.NewOrder(1, 1)), true)
+	assertEq(ladder.AddOrder(2, orderbook.NewOrder(2, 2)), true)
+	assertEq(ladder.AddOrder(5, orderbook.NewOrder(3, 3)), true)
+	assertEq(ladder.AddOrder(1, orderbook.NewOrder(4, 4)), true)
+	assertEq(ladder.AddOrder(3, orderbook.NewOrder(5, 5)), true)
+	assertEq(ladder.RemoveOrder(4, 1), true)
+	assertEq(ladder.RemoveOrder(4, 1), false)
+
+	expected := []int64{1, 2, 3, 5}
 	index := 0
 
 	ladder.Walk(func(level *orderbook.Level) bool {
 		t.Helper()
 
-		if level.Price.String() != expected[index] {
-			t.Errorf("have %v, want %s", level.Price, expected[index])
+		if level.Price != expected[index] {
+			t.Errorf("have %d, want %d", level.Price, expected[index])
 		}
 		index++
 
@@ -100,16 +99,14 @@ func TestLadder_RemoveOrder(t *testing.T) {
 	})
 }
 
-func TestLadder_MatchOrderLimit_1(t *testing.T) {
+func TestLadder_MatchLevel_1(t *testing.T) {
 	t.Parallel()
 
 	ladder := orderbook.NewLadder(orderbook.Ask)
 	check := func(present bool, price int64) int {
 		t.Helper()
 
-		dec := decimal.NewFromInt(price)
-		key := orderbook.LevelMapKey(dec)
-		level, levelOK := ladder.Mapping[key]
+		level, levelOK := ladder.GetLevel(price)
 
 		if levelOK != present {
 			t.Errorf("have %t, want %t", levelOK, present)
@@ -122,38 +119,36 @@ func TestLadder_MatchOrderLimit_1(t *testing.T) {
 		return 0
 	}
 
-	ladder.AddOrder(decimal.NewFromInt(9), orderbook.NewOrder("id1", decimal.NewFromInt(10)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id2", decimal.NewFromInt(1)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id3", decimal.NewFromInt(2)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id4", decimal.NewFromInt(3)))
-	ladder.AddOrder(decimal.NewFromInt(11), orderbook.NewOrder("id5", decimal.NewFromInt(10)))
+	ladder.AddOrder(9, orderbook.NewOrder(1, 10))
+	ladder.AddOrder(10, orderbook.NewOrder(2, 1))
+	ladder.AddOrder(10, orderbook.NewOrder(3, 2))
+	ladder.AddOrder(10, orderbook.NewOrder(4, 3))
+	ladder.AddOrder(11, orderbook.NewOrder(5, 10))
 
 	if have := check(true, 10); have != 3 {
 		t.Errorf("have %d, want 3", have)
 	}
 
-	left, matches := ladder.MatchOrderLimit(decimal.NewFromInt(10), orderbook.NewOrder("id6", decimal.NewFromInt(3)))
-	if !left.IsZero() {
-		t.Errorf("have %v, want 0", left)
+	left, matches := ladder.MatchLevel(10, orderbook.NewOrder(6, 3))
+	if left != 0 {
+		t.Errorf("have %d, want 0", left)
 	}
 
-	assertMatches(t, matches, map[string]string{"id2": "1", "id3": "2"})
+	assertMatches(t, matches, map[uint64]int64{2: 1, 3: 2})
 
 	if have := check(true, 10); have != 1 {
 		t.Errorf("have %d, want 1", have)
 	}
 }
 
-func TestLadder_MatchOrderLimit_2(t *testing.T) {
+func TestLadder_MatchLevel_2(t *testing.T) {
 	t.Parallel()
 
 	ladder := orderbook.NewLadder(orderbook.Ask)
 	check := func(present bool, price int64) int {
 		t.Helper()
 
-		dec := decimal.NewFromInt(price)
-		key := orderbook.LevelMapKey(dec)
-		level, levelOK := ladder.Mapping[key]
+		level, levelOK := ladder.GetLevel(price)
 
 		if levelOK != present {
 			t.Errorf("have %t, want %t", levelOK, present)
@@ -166,39 +161,37 @@ func TestLadder_MatchOrderLimit_2(t *testing.T) {
 		return 0
 	}
 
-	ladder.AddOrder(decimal.NewFromInt(9), orderbook.NewOrder("id1", decimal.NewFromInt(10)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id2", decimal.NewFromInt(1)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id3", decimal.NewFromInt(2)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id4", decimal.NewFromInt(3)))
-	ladder.AddOrder(decimal.NewFromInt(11), orderbook.NewOrder("id5", decimal.NewFromInt(10)))
+	ladder.AddOrder(9, orderbook.NewOrder(1, 10))
+	ladder.AddOrder(10, orderbook.NewOrder(2, 1))
+	ladder.AddOrder(10, orderbook.NewOrder(3, 2))
+	ladder.AddOrder(10, orderbook.NewOrder(4, 3))
+	ladder.AddOrder(11, orderbook.NewOrder(5, 10))
 
 	if have := check(true, 10); have != 3 {
 		t.Errorf("have %d, want 3", have)
 	}
 
-	left, matches := ladder.MatchOrderLimit(decimal.NewFromInt(10), orderbook.NewOrder("id6", decimal.NewFromInt(10)))
+	left, matches := ladder.MatchLevel(10, orderbook.NewOrder(6, 10))
 
-	if !left.Equal(decimal.NewFromInt(4)) {
-		t.Errorf("have %v, want 4", left)
+	if left != 4 {
+		t.Errorf("have %d, want 4", left)
 	}
 
-	assertMatches(t, matches, map[string]string{"id2": "1", "id3": "2", "id4": "3"})
+	assertMatches(t, matches, map[uint64]int64{2: 1, 3: 2, 4: 3})
 
 	if have := check(false, 10); have != 0 {
 		t.Errorf("have %d, want 0", have)
 	}
 }
 
-func TestLadder_MatchOrderLimit_3(t *testing.T) {
+func TestLadder_MatchLevel_3(t *testing.T) {
 	t.Parallel()
 
 	ladder := orderbook.NewLadder(orderbook.Ask)
 	check := func(present bool, price int64) int {
 		t.Helper()
 
-		dec := decimal.NewFromInt(price)
-		key := orderbook.LevelMapKey(dec)
-		level, levelOK := ladder.Mapping[key]
+		level, levelOK := ladder.GetLevel(price)
 
 		if levelOK != present {
 			t.Errorf("have %t, want %t", levelOK, present)
@@ -211,22 +204,22 @@ func TestLadder_MatchOrderLimit_3(t *testing.T) {
 		return 0
 	}
 
-	ladder.AddOrder(decimal.NewFromInt(9), orderbook.NewOrder("id1", decimal.NewFromInt(10)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id2", decimal.NewFromInt(1)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id3", decimal.NewFromInt(2)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id4", decimal.NewFromInt(3)))
-	ladder.AddOrder(decimal.NewFromInt(11), orderbook.NewOrder("id5", decimal.NewFromInt(10)))
+	ladder.AddOrder(9, orderbook.NewOrder(1, 10))
+	ladder.AddOrder(10, orderbook.NewOrder(2, 1))
+	ladder.AddOrder(10, orderbook.NewOrder(3, 2))
+	ladder.AddOrder(10, orderbook.NewOrder(4, 3))
+	ladder.AddOrder(11, orderbook.NewOrder(5, 10))
 
 	if have := check(true, 10); have != 3 {
 		t.Errorf("have %d, want 3", have)
 	}
 
-	left, matches := ladder.MatchOrderLimit(decimal.NewFromInt(10), orderbook.NewOrder("id6", decimal.NewFromInt(2)))
-	if !left.Equal(decimal.Zero) {
-		t.Errorf("have %v, want 0", left)
+	left, matches := ladder.MatchLevel(10, orderbook.NewOrder(6, 2))
+	if left != 0 {
+		t.Errorf("have %d, want 0", left)
 	}
 
-	assertMatches(t, matches, map[string]string{"id2": "1", "id3": "1"})
+	assertMatches(t, matches, map[uint64]int64{2: 1, 3: 1})
 	// fmt.Printf("%v\n", d.heap)
 
 	if have := check(true, 10); have != 2 {
@@ -234,6 +227,57 @@ func TestLadder_MatchOrderLimit_3(t *testing.T) {
 	}
 }
 
+func TestLadder_MatchOrderLimit_1(t *testing.T) {
+	t.Parallel()
+
+	ladder := orderbook.NewLadder(orderbook.Ask)
+	ladder.AddOrder(9, orderbook.NewOrder(1, 10))
+	ladder.AddOrder(10, orderbook.NewOrder(2, 1))
+	ladder.AddOrder(10, orderbook.NewOrder(3, 2))
+	ladder.AddOrder(11, orderbook.NewOrder(4, 10))
+
+	// A buy at 10 sweeps levels 9 and 10, but never touches 11.
+	left, matches := ladder.MatchOrderLimit(10, orderbook.NewOrder(5, 20))
+	if left != 7 {
+		t.Errorf("have %d, want 7", left)
+	}
+
+	assertMatches(t, matches, map[uint64]int64{1: 10, 2: 1, 3: 2})
+
+	if have := ladder.Len(); have != 1 {
+		t.Errorf("have %d, want 1", have)
+	}
+
+	if ladder.Best().Price != 11 {
+		t.Errorf("have %d, want 11", ladder.Best().Price)
+	}
+}
+
+func TestLadder_MatchOrderLimit_2(t *testing.T) {
+	t.Parallel()
+
+	ladder := orderbook.NewLadder(orderbook.Bid)
+	ladder.AddOrder(12, orderbook.NewOrder(1, 1))
+	ladder.AddOrder(11, orderbook.NewOrder(2, 2))
+	ladder.AddOrder(10, orderbook.NewOrder(3, 3))
+
+	// A sell at 11 takes the bids at 12 and 11 and stops there.
+	left, matches := ladder.MatchOrderLimit(11, orderbook.NewOrder(4, 2))
+	if left != 0 {
+		t.Errorf("have %d, want 0", left)
+	}
+
+	assertMatches(t, matches, map[uint64]int64{1: 1, 2: 1})
+
+	if ladder.Crosses(12) {
+		t.Error()
+	}
+
+	if !ladder.Crosses(11) {
+		t.Error()
+	}
+}
+
 func TestLadder_MatchOrderMarket_1(t *testing.T) {
 	t.Parallel()
 
@@ -241,9 +285,7 @@ func TestLadder_MatchOrderMarket_1(t *testing.T) {
 	check := func(present bool, price int64) int {
 		t.Helper()
 
-		dec := decimal.NewFromInt(price)
-		key := orderbook.LevelMapKey(dec)
-		level, levelOK := ladder.Mapping[key]
+		level, levelOK := ladder.GetLevel(price)
 
 		if levelOK != present {
 			t.Errorf("have %t, want %t", levelOK, present)
@@ -256,18 +298,18 @@ func TestLadder_MatchOrderMarket_1(t *testing.T) {
 		return 0
 	}
 
-	ladder.AddOrder(decimal.NewFromInt(9), orderbook.NewOrder("id1", decimal.NewFromInt(10)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id2", decimal.NewFromInt(1)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id3", decimal.NewFromInt(2)))
-	ladder.AddOrder(decimal.NewFromInt(10), orderbook.NewOrder("id4", decimal.NewFromInt(3)))
-	ladder.AddOrder(decimal.NewFromInt(11), orderbook.NewOrder("id5", decimal.NewFromInt(10)))
+	ladder.AddOrder(9, orderbook.NewOrder(1, 10))
+	ladder.AddOrder(10, orderbook.NewOrder(2, 1))
+	ladder.AddOrder(10, orderbook.NewOrder(3, 2))
+	ladder.AddOrder(10, orderbook.NewOrder(4, 3))
+	ladder.AddOrder(11, orderbook.NewOrder(5, 10))
 
-	left, matches := ladder.MatchOrderMarket(orderbook.NewOrder("id6", decimal.NewFromInt(20)))
-	if !left.Equal(decimal.Zero) {
-		t.Errorf("have %v, want 0", left)
+	left, matches := ladder.MatchOrderMarket(orderbook.NewOrder(6, 20))
+	if left != 0 {
+		t.Errorf("have %d, want 0", left)
 	}
 
-	assertMatches(t, matches, map[string]string{"id1": "10", "id2": "1", "id3": "2", "id4": "3", "id5": "4"})
+	assertMatches(t, matches, map[uint64]int64{1: 10, 2: 1, 3: 2, 4: 3, 5: 4})
 
 	if check(false, 9) != 0 || check(false, 10) != 0 || check(true, 11) != 1 {
 		t.Error()
@@ -279,22 +321,95 @@ func TestLadder_GetOrder(t *testing.T) {
 
 	ladder := orderbook.NewLadder(orderbook.Ask)
 
-	if _, ok := ladder.GetOrder(decimal.NewFromInt(9), "id1"); ok {
+	if _, ok := ladder.GetOrder(9, 1); ok {
 		t.Error()
 	}
 
-	ladder.AddOrder(decimal.NewFromInt(9), orderbook.NewOrder("id1", decimal.NewFromInt(10)))
+	ladder.AddOrder(9, orderbook.NewOrder(1, 10))
 
-	order, orderOK := ladder.GetOrder(decimal.NewFromInt(9), "id1")
+	order, orderOK := ladder.GetOrder(9, 1)
 	if !orderOK {
 		t.Error()
 	}
 
-	if order.ID != "id1" {
+	if order.Handle != 1 {
 		t.Error()
 	}
 
-	if !order.Quantity.Equal(decimal.NewFromInt(10)) {
+	if order.Quantity != 10 {
 		t.Error()
 	}
 }
+
+// Consumed iceberg slices get replenished and lose their time priority.
+func TestLadder_MatchLevel_Iceberg(t *testing.T) {
+	t.Parallel()
+
+	ladder := orderbook.NewLadder(orderbook.Ask)
+	ladder.AddOrder(10, orderbook.NewIcebergOrder(1, 5, 2))
+	ladder.AddOrder(10, orderbook.NewOrder(2, 3))
+
+	if have := ladder.TotalQuantity(10); have != 5 {
+		t.Errorf("have %d, want 5", have)
+	}
+
+	// Takes 2 from id1, which goes to the back with a new slice of 2, and
+	// then 1 from id2.
+	left, matches := ladder.MatchLevel(10, orderbook.NewOrder(3, 3))
+	if left != 0 {
+		t.Errorf("have %d, want 0", left)
+	}
+
+	assertMatches(t, matches, map[uint64]int64{1: 2, 2: 1})
+
+	level, _ := ladder.GetLevel(10)
+	orders := level.Orders.Iter()
+	if len(orders) != 2 || orders[0].Handle != 2 || orders[1].Handle != 1 {
+		t.Errorf("unexpected queue %v", orders)
+	}
+
+	if orders[1].Quantity != 2 || orders[1].Hidden != 1 {
+		t.Errorf("unexpected order %v", orders[1])
+	}
+
+	// Sweeping the whole level consumes all the hidden quantity as well.
+	left, matches = ladder.MatchLevel(10, orderbook.NewOrder(4, 10))
+	if left != 5 {
+		t.Errorf("have %d, want 5", left)
+	}
+
+	if len(matches) != 3 {
+		t.Errorf("have %d matches, want 3", len(matches))
+	}
+
+	if ladder.Len() != 0 {
+		t.Errorf("have %d levels, want 0", ladder.Len())
+	}
+}
+
+// Handles are unique across all levels of a ladder.
+func TestLadder_AddOrder_Handle(t *testing.T) {
+	t.Parallel()
+
+	ladder := orderbook.NewLadder(orderbook.Ask)
+
+	if !ladder.AddOrder(10, orderbook.NewOrder(1, 1)) {
+		t.Error("not added")
+	}
+
+	if ladder.AddOrder(11, orderbook.NewOrder(1, 1)) {
+		t.Error("added twice")
+	}
+
+	if ladder.RemoveOrder(11, 1) {
+		t.Error("removed from the wrong level")
+	}
+
+	if ladder.Len() != 1 {
+		t.Errorf("have %d, want 1", ladder.Len())
+	}
+
+	if !ladder.RemoveOrder(10, 1) || !ladder.AddOrder(11, orderbook.NewOrder(1, 1)) {
+		t.Error("handle not released")
+	}
+}
@@ -3,8 +3,6 @@ package orderbook
 import (
 	"fmt"
 	"strings"
-
-	"github.com/shopspring/decimal"
 )
 
 // +-------+
@@ -17,38 +15,44 @@ const (
 )
 
 // Level represents a level in the order book (either ask or bid).  It
-// has a price and a queue of limit orders waiting to get executed.
+// has a price, in ticks of the instrument, and a queue of limit orders
+// waiting to get executed.
 type Level struct {
-	Price  decimal.Decimal // Also serves as Key() in the heap.
-	Orders OrderQueue      // All of the orders on this level.
-	Type   int             // Ask or Bid, controls behavior of Key().
-	index  int             // Heap index.
+	Price  int64      // Also serves as Key() in the heap.
+	Orders OrderQueue // All of the orders on this level.
+	Type   int        // Ask or Bid, controls behavior of Key().
+	index  int        // Heap index.
 }
 
-func NewLevel(price decimal.Decimal, levelType int) *Level {
+func NewLevel(price int64, levelType int) *Level {
 	const queueSize = 16
 
+	return newLevel(price, levelType, newOrderIndex(queueSize))
+}
+
+// newLevel creates a level whose queue shares the given handle index.
+func newLevel(price int64, levelType int, orders *orderIndex) *Level {
 	return &Level{
 		Price:  price,
-		Orders: NewOrderQueue(queueSize),
+		Orders: newOrderQueue(orders),
 		Type:   levelType,
 		index:  0,
 	}
 }
 
-func (v *Level) Key() decimal.Decimal {
+func (v *Level) Key() int64 {
 	switch v.Type {
 	case Ask:
 		return v.Price
 	case Bid:
-		return v.Price.Neg()
+		return -v.Price
 	default:
 		panic("illegal type")
 	}
 }
 
 func (v *Level) Less(rhs *Level) bool {
-	return v.Key().LessThan(rhs.Key())
+	return v.Key() < rhs.Key()
 }
 
 func (v *Level) String() string {
@@ -58,15 +62,26 @@ func (v *Level) String() string {
 		side = "bid"
 	}
 
-	return fmt.Sprintf("  [Level Price=%v Orders(%d) Type=%s]\n%s",
+	return fmt.Sprintf("  [Level Price=%d Orders(%d) Type=%s]\n%s",
 		v.Price, v.Orders.Len(), side, v.Orders.String())
 }
 
-func (v *Level) TotalQuantity() decimal.Decimal {
-	ans := decimal.Zero
+func (v *Level) TotalQuantity() int64 {
+	var ans int64
+
+	for x := v.Orders.Peek(); x != nil; x = x.Next() {
+		ans += x.Quantity
+	}
+
+	return ans
+}
+
+// HiddenQuantity sums up the quantity iceberg orders keep hidden.
+func (v *Level) HiddenQuantity() int64 {
+	var ans int64
 
-	for _, x := range v.Orders.Iter() {
-		ans = ans.Add(x.Quantity)
+	for x := v.Orders.Peek(); x != nil; x = x.Next() {
+		ans += x.Hidden
 	}
 
 	return ans
@@ -154,9 +169,3 @@ func (h *LevelHeap) Pop() interface{} {
 
 // LevelMap maps Price to Level.
 type LevelMap map[int64]*Level
-
-func LevelMapKey(d decimal.Decimal) int64 {
-	const K = 1_0000_0000
-
-	return d.Mul(decimal.NewFromInt(K)).IntPart()
-}
@@ -1,15 +1,12 @@
 package orderbook
 
 import (
-	"container/heap"
 	"fmt"
-
-	"github.com/shopspring/decimal"
 )
 
 type Item struct {
 	index    int
-	priority decimal.Decimal
+	priority int64
 }
 
 func (i *Item) String() string {
@@ -27,7 +24,7 @@ func NewPriorityQueue(n int) PriorityQueue {
 func (pq PriorityQueue) Len() int { return len(pq) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
-	return pq[i].priority.LessThan(pq[j].priority)
+	return pq[i].priority < pq[j].priority
 }
 
 func (pq PriorityQueue) Swap(i, j int) {
@@ -52,6 +49,54 @@ func (pq *PriorityQueue) Pop() interface{} {
 	return item
 }
 
+// push adds the given item, just like heap.Push, but without boxing it
+// into an interface.
+func (pq *PriorityQueue) push(item Item) {
+	*pq = append(*pq, item)
+	q := *pq
+
+	for i := len(q) - 1; i > 0; {
+		parent := (i - 1) / 2
+		if !q.Less(i, parent) {
+			break
+		}
+
+		q.Swap(i, parent)
+		i = parent
+	}
+}
+
+// pop removes the item of the lowest priority, just like heap.Pop, but
+// without boxing it into an interface.
+func (pq *PriorityQueue) pop() Item {
+	q := *pq
+	n := len(q) - 1
+	q.Swap(0, n)
+
+	for i := 0; ; {
+		child := 2*i + 1
+		if child >= n {
+			break
+		}
+
+		if right := child + 1; right < n && q.Less(right, child) {
+			child = right
+		}
+
+		if !q.Less(child, i) {
+			break
+		}
+
+		q.Swap(i, child)
+		i = child
+	}
+
+	item := q[n]
+	*pq = q[:n]
+
+	return item
+}
+
 // Walk in order over Levels in a LevelHeap.
 func Walk(levels LevelHeap, each func(level *Level) bool) {
 	const (
@@ -62,7 +107,7 @@ func Walk(levels LevelHeap, each func(level *Level) bool) {
 	indices := NewPriorityQueue(levels.Len()/2 + 1)
 	push := func(i int) {
 		if i < levels.Len() {
-			heap.Push(&indices, Item{
+			indices.push(Item{
 				index:    i,
 				priority: levels[i].Key(),
 			})
@@ -72,13 +117,7 @@ func Walk(levels LevelHeap, each func(level *Level) bool) {
 	push(0)
 
 	for indices.Len() > 0 {
-		item, ok := heap.Pop(&indices).(Item)
-
-		if !ok {
-			continue
-		}
-
-		index := item.index
+		index := indices.pop().index
 
 		if index >= len(levels) {
 			continue
@@ -4,28 +4,27 @@ import (
 	"container/heap"
 	"testing"
 
-	"github.com/shopspring/decimal"
 	"github.com/ydm/orderbook"
 )
 
 func TestLevelHeap_Walk(t *testing.T) {
 	t.Parallel()
 
-	check := func(levelType int, expected []string) {
+	check := func(levelType int, expected []int64) {
 		levels := orderbook.NewLevelHeap(16)
-		heap.Push(&levels, orderbook.NewLevel(decimal.NewFromInt(4), levelType))
-		heap.Push(&levels, orderbook.NewLevel(decimal.NewFromInt(2), levelType))
-		heap.Push(&levels, orderbook.NewLevel(decimal.NewFromInt(5), levelType))
-		heap.Push(&levels, orderbook.NewLevel(decimal.NewFromInt(1), levelType))
-		heap.Push(&levels, orderbook.NewLevel(decimal.NewFromInt(3), levelType))
+		heap.Push(&levels, orderbook.NewLevel(4, levelType))
+		heap.Push(&levels, orderbook.NewLevel(2, levelType))
+		heap.Push(&levels, orderbook.NewLevel(5, levelType))
+		heap.Push(&levels, orderbook.NewLevel(1, levelType))
+		heap.Push(&levels, orderbook.NewLevel(3, levelType))
 
 		index := 0
 
 		levels.Walk(func(level *orderbook.Level) bool {
 			t.Helper()
 
-			if level.Price.String() != expected[index] {
-				t.Errorf("have %v, want %s", level.Price, expected[index])
+			if level.Price != expected[index] {
+				t.Errorf("have %d, want %d", level.Price, expected[index])
 			}
 			index++
 
@@ -33,6 +32,19 @@ func TestLevelHeap_Walk(t *testing.T) {
 		})
 	}
 
-	check(orderbook.Ask, []string{"1", "2", "3", "4", "5"})
-	check(orderbook.Bid, []string{"5", "4", "3", "2", "1"})
+	check(orderbook.Ask, []int64{1, 2, 3, 4, 5})
+	check(orderbook.Bid, []int64{5, 4, 3, 2, 1})
+}
+
+func BenchmarkLevelHeap_Walk(b *testing.B) {
+	levels := orderbook.NewLevelHeap(benchmarkLevels)
+	for i := 0; i < benchmarkLevels; i++ {
+		heap.Push(&levels, orderbook.NewLevel(benchmarkPrice(i*7919), orderbook.Bid))
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		levels.Walk(func(level *orderbook.Level) bool { return true })
+	}
 }
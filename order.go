@@ -2,26 +2,111 @@ package orderbook
 
 import (
 	"fmt"
-
-	"github.com/shopspring/decimal"
 )
 
+// Order is an order resting in a ladder.  It is known by its handle,
+// see ClientOrder.Handle, and its quantities are in lots of the
+// instrument, see Instrument.Lots.
 type Order struct {
-	//nolint:godox
-	//TODO: Turn ID into int64!
-	ID             string          // 16 bytes
-	Quantity       decimal.Decimal // 16 bytes
-	InsertionIndex int             //  8 bytes
-} //                      Total: at least 40 bytes
-
-func NewOrder(id string, quantity decimal.Decimal) Order {
+	Handle         uint64 // 8 bytes
+	Quantity       int64  // 8 bytes, visible quantity
+	InsertionIndex int    // 8 bytes
+
+	// Iceberg orders show only Display of their quantity at a time and
+	// keep the rest hidden.
+	Hidden  int64 // 8 bytes
+	Display int64 // 8 bytes, zero for regular orders
+
+	// Orders of the same (non-empty) owner never trade with each other,
+	// SelfTrade is the taker's self-trade prevention mode.
+	Owner     string // 16 bytes
+	SelfTrade int    //  8 bytes
+
+	// Neighbours in the queue of the level and the queue itself, see
+	// OrderQueue.
+	prev  *Order      // 8 bytes
+	next  *Order      // 8 bytes
+	queue *OrderQueue // 8 bytes
+} //                      Total: at least 88 bytes
+
+func NewOrder(handle uint64, quantity int64) Order {
 	return Order{
-		ID:             id,
+		Handle:         handle,
 		Quantity:       quantity,
 		InsertionIndex: 0,
+		Hidden:         0,
+		Display:        0,
+		Owner:          "",
+		SelfTrade:      SelfTradeCancelNewest,
+		prev:           nil,
+		next:           nil,
+		queue:          nil,
 	}
 }
 
+// NewIcebergOrder creates an order that shows at most display of its
+// quantity at a time.
+func NewIcebergOrder(handle uint64, quantity, display int64) Order {
+	order := NewOrder(handle, quantity)
+	order.Display = display
+	order.replenish()
+
+	return order
+}
+
+// Next returns the order behind this one in the queue of its level, or
+// nil.
+func (o *Order) Next() *Order {
+	return o.next
+}
+
+// detached returns a copy of the order that is not linked to any queue.
+func (o Order) detached() Order {
+	o.prev, o.next, o.queue = nil, nil, nil
+
+	return o
+}
+
+// IsIceberg reports whether the order hides some of its quantity.
+func (o Order) IsIceberg() bool {
+	return o.Display > 0
+}
+
+// replenish moves up to Display of the hidden quantity into the visible
+// one.  For regular orders this is a no-op.
+func (o *Order) replenish() {
+	if !o.IsIceberg() {
+		return
+	}
+
+	total := o.Quantity + o.Hidden
+	o.Quantity = minInt64(total, o.Display)
+	o.Hidden = total - o.Quantity
+}
+
+// Total returns the visible and hidden quantity of the order.
+func (o Order) Total() int64 {
+	return o.Quantity + o.Hidden
+}
+
+// selfTrades reports whether this (taker) order must not trade with the
+// given maker order.
+func (o Order) selfTrades(maker Order) bool {
+	return o.Owner != "" && o.Owner == maker.Owner && o.SelfTrade != SelfTradeAllow
+}
+
+// reduce takes the given quantity off the order, hidden quantity first,
+// so the order keeps its place in the queue.
+func (o *Order) reduce(quantity int64) {
+	hidden := minInt64(quantity, o.Hidden)
+	o.Hidden -= hidden
+	o.Quantity -= quantity - hidden
+}
+
 func (o Order) String() string {
-	return fmt.Sprintf("[Order ID=%s Quantity=%v]", o.ID, o.Quantity)
+	if o.IsIceberg() {
+		return fmt.Sprintf("[Order Handle=%d Quantity=%d Hidden=%d]", o.Handle, o.Quantity, o.Hidden)
+	}
+
+	return fmt.Sprintf("[Order Handle=%d Quantity=%d]", o.Handle, o.Quantity)
 }
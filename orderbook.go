@@ -4,62 +4,243 @@ package orderbook
 
 import (
 	"errors"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
 
 var (
+	ErrCannotAmendOrder            = errors.New("given order is not eligible for amendment")
 	ErrCannotCancelMarketOrder     = errors.New("cannot cancel market order")
 	ErrCannotCancelOrder           = errors.New("given order is not eligible for cancelation")
+	ErrFillOrKillNotFilled         = errors.New(ReasonFillOrKill)
+	ErrInvalidDisplayQuantity      = errors.New("invalid order display quantity")
+	ErrInvalidExpireTime           = errors.New("invalid order expire time")
+	ErrInvalidInstrument           = errors.New("invalid instrument specification")
+	ErrInvalidInterval             = errors.New("invalid candle interval")
 	ErrInvalidID                   = errors.New("invalid order ID")
+	ErrInvalidPostOnly             = errors.New("invalid order post-only mode")
 	ErrInvalidPrice                = errors.New("invalid order price")
 	ErrInvalidQuantity             = errors.New("invalid order quantity")
+	ErrInvalidQuoteQuantity        = errors.New("invalid order quote quantity")
+	ErrInvalidSelfTrade            = errors.New("invalid order self-trade prevention mode")
+	ErrInvalidSequence             = errors.New("invalid sequence number")
 	ErrInvalidSide                 = errors.New("invalid order side")
+	ErrInvalidSlippage             = errors.New("invalid order max slippage")
+	ErrInvalidStopPrice            = errors.New("invalid order stop price")
+	ErrInvalidSymbol               = errors.New("invalid order symbol")
+	ErrInvalidTimeInForce          = errors.New("invalid order time in force")
+	ErrInvalidTransition           = errors.New("invalid order state transition")
 	ErrInvalidType                 = errors.New("invalid order type")
-	ErrMarketOrderNotFullyExecuted = errors.New("market order not (fully) executed")
+	ErrLadderOverflow              = errors.New(ReasonOverflow)
+	ErrMarketOrderNotFullyExecuted = errors.New(ReasonMarket)
 	ErrMarketOrderHasPrice         = errors.New("given market order has price set")
+	ErrNotionalTooSmall            = errors.New("order notional is below minimum")
 	ErrOrderDoesNotExist           = errors.New("order with this ID does not exist")
+	ErrOrderAlreadyCanceled        = errors.New("order is already canceled")
+	ErrOrderAlreadyFilled          = errors.New("order is already filled")
 	ErrOrderExists                 = errors.New("order with this ID already exists")
+	ErrOrderNotResting             = errors.New("order is not resting in the book")
+	ErrPostOnlyWouldTake           = errors.New("post-only order would take liquidity")
+	ErrPriceOffTick                = errors.New("order price is not a multiple of the tick size")
+	ErrPriceOutOfRange             = errors.New("order price is out of the book's range")
+	ErrPriceOverflow               = errors.New("order price is too large")
+	ErrPricePrecision              = errors.New("order price has too many decimal places")
+	ErrQuantityOffLot              = errors.New("order quantity is not a multiple of the lot size")
+	ErrQuantityOverflow            = errors.New("order quantity is too large")
+	ErrQuantityPrecision           = errors.New("order quantity has too many decimal places")
+	ErrQuantityTooLarge            = errors.New("order quantity is above maximum")
+	ErrQuantityTooSmall            = errors.New("order quantity is below minimum")
+	ErrSequenceTooOld              = errors.New("sequence number is too old")
+	ErrSymbolExists                = errors.New("book with this symbol already exists")
+	ErrUnknownSymbol               = errors.New("unknown symbol")
 )
 
 type Book struct {
+	Symbol     string
+	Instrument Instrument
+
 	Asks Ladder
 	Bids Ladder
 	mu   sync.Mutex
 
-	// Ser, please imagine this is a database.
-	database      map[string]ClientOrder
+	// Stop orders wait here, keyed by their stop price, until the last
+	// traded price reaches it.
+	buyStops  Ladder
+	sellStops Ladder
+
+	// Ser, please imagine this is a database.  Orders are keyed by their
+	// handle and ids maps client order IDs to handles.  Ladders only ever
+	// see handles.
+	database      map[uint64]ClientOrder
+	ids           map[string]uint64
 	databaseMutex sync.Mutex
+
+	// lastHandle is the last handle assigned.  Books of an exchange
+	// share it, so handles are unique across the whole exchange.
+	lastHandle *uint64
+
+	// expiries maps the handle of each resting GTD or DAY order to its
+	// expire time and expiryQueue orders them by it, see expireOrders.
+	expiries    map[uint64]time.Time
+	expiryQueue expiryHeap
+
+	// trades keeps the history of the last MaxTrades executions, oldest
+	// first.
+	trades      []Trade
+	nextTradeID int64
+
+	// Candles of each interval, fed by the trades.
+	candles         []candleSeries
+	candleIntervals []time.Duration
+	stats           rollingStats
+
+	// sequence numbers the events of the book, see emit.
+	sequence        uint64
+	subscribers     []*Subscription
+	eventQueueLimit int
+
+	// Levels changed by the current operation, see touch.
+	touched       map[levelKey]struct{}
+	touchedLevels []levelKey
+
+	// levelChanges logs the level changes since sequence number
+	// levelsSince, for diffs.
+	levelChanges []levelChange
+	levelsSince  uint64
+	depthHistory int
+
+	now func() time.Time
 }
 
-func NewBook() *Book {
-	return &Book{
-		Asks:          NewLadder(Ask),
-		Bids:          NewLadder(Bid),
-		mu:            sync.Mutex{},
-		database:      make(map[string]ClientOrder),
-		databaseMutex: sync.Mutex{},
+// BookOption configures a Book created with NewBook.
+type BookOption func(*Book)
+
+// WithSymbol sets the symbol of the instrument traded in the book.
+func WithSymbol(symbol string) BookOption {
+	return func(b *Book) {
+		b.Symbol = symbol
 	}
 }
 
-func (b *Book) checkOrder(order ClientOrder) error {
-	// Check order properties.
-	if order.OriginalQuantity.LessThanOrEqual(decimal.Zero) {
-		return ErrInvalidQuantity
+// WithInstrument sets the specification of the instrument traded in the
+// book.
+func WithInstrument(instrument Instrument) BookOption {
+	return func(b *Book) {
+		b.Instrument = instrument
 	}
+}
 
-	if !order.ExecutedQuantity.IsZero() {
-		return ErrInvalidQuantity
+// withHandles makes the book assign handles from the given counter, so
+// it can be shared with other books.
+func withHandles(lastHandle *uint64) BookOption {
+	return func(b *Book) {
+		b.lastHandle = lastHandle
+	}
+}
+
+// WithClock makes the book read the current time from the given
+// function instead of time.Now.
+func WithClock(now func() time.Time) BookOption {
+	return func(b *Book) {
+		b.now = now
+	}
+}
+
+// NewBook creates an empty book.  It panics if the instrument given with
+// WithInstrument, the history given with WithDepthHistory, the intervals
+// given with WithCandleIntervals, the window given with WithStatsWindow
+// or the limit given with WithEventQueueLimit are not valid, or if the
+// tick size of a TickBackend is not the one of the instrument.
+func NewBook(options ...BookOption) *Book {
+	b := &Book{
+		Symbol:          "",
+		Instrument:      DefaultInstrument(),
+		Asks:            NewLadder(Ask),
+		Bids:            NewLadder(Bid),
+		mu:              sync.Mutex{},
+		buyStops:        NewLadder(Ask),
+		sellStops:       NewLadder(Bid),
+		database:        make(map[uint64]ClientOrder),
+		ids:             make(map[string]uint64),
+		databaseMutex:   sync.Mutex{},
+		lastHandle:      new(uint64),
+		expiries:        make(map[uint64]time.Time),
+		expiryQueue:     make(expiryHeap, 0),
+		trades:          make([]Trade, 0, 256),
+		nextTradeID:     1,
+		candles:         make([]candleSeries, 0),
+		candleIntervals: DefaultCandleIntervals(),
+		stats:           newRollingStats(DefaultStatsWindow),
+		sequence:        0,
+		subscribers:     make([]*Subscription, 0),
+		eventQueueLimit: DefaultEventQueueLimit,
+		touched:         make(map[levelKey]struct{}),
+		touchedLevels:   make([]levelKey, 0),
+		levelChanges:    make([]levelChange, 0),
+		levelsSince:     0,
+		depthHistory:    DefaultDepthHistory,
+		now:             time.Now,
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	// All ladders of the book share a single handle index.
+	orders := newOrderIndex(0)
+	for _, ladder := range []*Ladder{&b.Asks, &b.Bids, &b.buyStops, &b.sellStops} {
+		ladder.orders = orders
+	}
+
+	if err := b.Instrument.Validate(); err != nil {
+		panic(err)
+	}
+
+	// Tick arrays place levels by price in ticks, which would land in
+	// the wrong slots with a different tick size.
+	for _, ladder := range []*Ladder{&b.Asks, &b.Bids} {
+		if a, ok := ladder.Levels.(*tickArray); ok && !a.tickSize.Equal(b.Instrument.TickSize) {
+			panic("tick size of the ladder backend does not match the instrument")
+		}
+	}
+
+	if b.depthHistory < 1 {
+		panic("invalid depth history")
+	}
+
+	if b.eventQueueLimit < 1 {
+		panic("invalid event queue limit")
+	}
+
+	if b.stats.window <= 0 {
+		panic("invalid stats window")
 	}
 
-	if order.ID == "" {
+	for _, interval := range b.candleIntervals {
+		if interval <= 0 {
+			panic(ErrInvalidInterval)
+		}
+
+		b.candles = append(b.candles, newCandleSeries(interval, MaxCandles))
+	}
+
+	return b
+}
+
+// checkID makes sure the given order ID is valid and not used by another
+// order.
+func (b *Book) checkID(id string) error {
+	if id == "" {
 		return ErrInvalidID
 	}
 
 	// Check if order with this ID already exists.
 	b.databaseMutex.Lock()
-	_, ok := b.database[order.ID] //nolint:ifshort
+	_, ok := b.ids[id] //nolint:ifshort
 	b.databaseMutex.Unlock()
 
 	if ok {
@@ -69,6 +250,156 @@ func (b *Book) checkOrder(order ClientOrder) error {
 	return nil
 }
 
+// lookup returns the order with the given client ID.
+func (b *Book) lookup(id string) (ClientOrder, bool) {
+	b.databaseMutex.Lock()
+	defer b.databaseMutex.Unlock()
+
+	order, ok := b.database[b.ids[id]]
+
+	return order, ok
+}
+
+// checkOrder validates the properties of the given order and converts its
+// prices and quantities to units.  It only depends on the instrument of
+// the book, so the caller need not hold b.mu, see checkPlacement.
+func (b *Book) checkOrder(order *ClientOrder) error {
+	// Orders copied from the book carry the units of the original, which
+	// must not leak into the new one.
+	order.units = orderUnits{price: 0, stopPrice: 0, quantity: 0, display: 0, executed: 0, prevented: 0}
+
+	// Check order properties.
+	if !order.ExecutedQuantity.IsZero() {
+		return ErrInvalidQuantity
+	}
+
+	if order.QuoteQuantity.IsZero() {
+		if order.OriginalQuantity.LessThanOrEqual(decimal.Zero) {
+			return ErrInvalidQuantity
+		}
+
+		lots, err := b.Instrument.checkQuantity(order.OriginalQuantity)
+		if err != nil {
+			return err
+		}
+
+		order.units.quantity = lots
+	} else if err := b.checkQuoteQuantity(*order); err != nil {
+		return err
+	}
+
+	if order.MaxSlippage.IsNegative() || (order.MaxSlippage.IsPositive() && !IsMarket(order.Type)) {
+		return ErrInvalidSlippage
+	}
+
+	if order.Symbol != b.Symbol {
+		return ErrInvalidSymbol
+	}
+
+	if _, _, err := b.matchSides(order.Side); err != nil {
+		return err
+	}
+
+	switch order.Type {
+	case TypeMarket, TypeStop:
+		if !order.Price.IsZero() {
+			return ErrMarketOrderHasPrice
+		}
+	case TypeLimit, TypeStopLimit:
+		if order.Price.IsNegative() {
+			return ErrInvalidPrice
+		}
+
+		ticks, err := b.Instrument.checkPrice(order.Price)
+		if err != nil {
+			return err
+		}
+
+		order.units.price = ticks
+
+		if err := b.Instrument.CheckNotional(order.Price, order.OriginalQuantity); err != nil {
+			return err
+		}
+	default:
+		return ErrInvalidType
+	}
+
+	if err := b.checkStopPrice(order); err != nil {
+		return err
+	}
+
+	if err := b.checkDisplayQuantity(order); err != nil {
+		return err
+	}
+
+	switch order.PostOnly {
+	case PostOnlyNone:
+	case PostOnlyReject, PostOnlySlide:
+		if IsMarket(order.Type) {
+			return ErrInvalidPostOnly
+		}
+	default:
+		return ErrInvalidPostOnly
+	}
+
+	if order.SelfTrade < SelfTradeCancelNewest || order.SelfTrade > SelfTradeAllow {
+		return ErrInvalidSelfTrade
+	}
+
+	b.normalize(order)
+
+	return nil
+}
+
+// checkPlacement validates the given order, already checked by
+// checkOrder, against the current state of the book.  The caller must
+// hold b.mu.
+func (b *Book) checkPlacement(order *ClientOrder, now time.Time) error {
+	if order.Type == TypeLimit || order.Type == TypeStopLimit {
+		if err := b.checkRange(*order); err != nil {
+			return err
+		}
+	}
+
+	if err := b.checkCapacity(*order); err != nil {
+		return err
+	}
+
+	return b.checkTimeInForce(order, now)
+}
+
+// normalize rewrites the quantities of the given validated order from
+// its units.  They all end up with the exponent of the lot size, just
+// like the quantities of trades, so adding them up, as filling the order
+// does, never has to rescale them.
+func (b *Book) normalize(order *ClientOrder) {
+	order.OriginalQuantity = b.Instrument.Quantity(order.units.quantity)
+	order.ExecutedQuantity = b.Instrument.Quantity(order.units.executed)
+	order.PreventedQuantity = b.Instrument.Quantity(order.units.prevented)
+	order.LeavesQuantity = order.OriginalQuantity.Sub(order.ExecutedQuantity).Sub(order.PreventedQuantity)
+}
+
+// checkCapacity makes sure the ladder the given order would rest on can
+// take all of its quantity, see Ladder.Fits.  The caller must hold b.mu.
+func (b *Book) checkCapacity(order ClientOrder) error {
+	var ladder *Ladder
+
+	switch order.Type {
+	case TypeLimit:
+		ladder, _, _ = b.matchSides(order.Side)
+	case TypeStop, TypeStopLimit:
+		ladder = b.stopsOf(order.Side)
+	default:
+		return nil
+	}
+
+	if !ladder.Fits(order.units.quantity) {
+		return ErrLadderOverflow
+	}
+
+	return nil
+}
+
 func (b *Book) matchSides(side int) (*Ladder, *Ladder, error) {
 	switch side {
 	case SideBuy:
@@ -80,86 +411,690 @@ func (b *Book) matchSides(side int) (*Ladder, *Ladder, error) {
 	}
 }
 
-func (b *Book) store(order ClientOrder, matches Matches) {
+// checkStopPrice makes sure only stop orders have a stop price and it is
+// on the tick.
+func (b *Book) checkStopPrice(order *ClientOrder) error {
+	if !IsStop(order.Type) {
+		if !order.StopPrice.IsZero() {
+			return ErrInvalidStopPrice
+		}
+
+		return nil
+	}
+
+	if !order.StopPrice.IsPositive() {
+		return ErrInvalidStopPrice
+	}
+
+	ticks, err := b.Instrument.checkPrice(order.StopPrice)
+	if err != nil {
+		return err
+	}
+
+	order.units.stopPrice = ticks
+
+	return nil
+}
+
+// checkDisplayQuantity makes sure only limit orders are icebergs and
+// their display quantity is a whole number of lots.
+func (b *Book) checkDisplayQuantity(order *ClientOrder) error {
+	if order.DisplayQuantity.IsZero() {
+		return nil
+	}
+
+	if IsMarket(order.Type) || order.DisplayQuantity.IsNegative() {
+		return ErrInvalidDisplayQuantity
+	}
+
+	lots, err := b.Instrument.Lots(order.DisplayQuantity)
+	if err != nil {
+		return ErrInvalidDisplayQuantity
+	}
+
+	order.units.display = lots
+
+	return nil
+}
+
+// checkQuoteQuantity validates market orders sized in quote currency.
+// Their quantity gets calculated only when they get matched.
+func (b *Book) checkQuoteQuantity(order ClientOrder) error {
+	if !IsMarket(order.Type) || !order.QuoteQuantity.IsPositive() || !order.OriginalQuantity.IsZero() {
+		return ErrInvalidQuoteQuantity
+	}
+
+	if !isRounded(order.QuoteQuantity, MaxPrecision) {
+		return ErrInvalidQuoteQuantity
+	}
+
+	if order.QuoteQuantity.LessThan(b.Instrument.MinNotional) {
+		return ErrNotionalTooSmall
+	}
+
+	return nil
+}
+
+// checkPostOnly makes sure a post-only order would not match anything
+// on the opposite side of the book.  If it would, the order gets either
+// rejected or repriced one tick away from the touch, depending on its
+// post-only mode.  Makers for which live returns false are skipped, see
+// Ladder.sweep.  The caller must hold b.mu.
+func (b *Book) checkPostOnly(order *ClientOrder, live func(maker *Order) bool) error {
+	_, op, err := b.matchSides(order.Side)
+	if err != nil {
+		return err
+	}
+
+	if order.PostOnly == PostOnlyNone {
+		return nil
+	}
+
+	best := op.best(live)
+	if best == nil || !op.crosses(best, order.units.price) {
+		return nil
+	}
+
+	if order.PostOnly != PostOnlySlide {
+		return ErrPostOnlyWouldTake
+	}
+
+	touch := best.Price
+
+	switch order.Side {
+	case SideBuy:
+		order.units.price = touch - 1
+	case SideSell:
+		order.units.price = touch + 1
+	}
+
+	order.Price = b.Instrument.Price(order.units.price)
+
+	if order.Price.IsNegative() {
+		return ErrPostOnlyWouldTake
+	}
+
+	if err := b.checkRange(*order); err != nil {
+		return err
+	}
+
+	return b.Instrument.CheckNotional(order.Price, order.OriginalQuantity)
+}
+
+// checkRange makes sure the given limit order's price fits into its
+// ladder, see TickBackend.
+func (b *Book) checkRange(order ClientOrder) error {
+	my, _, err := b.matchSides(order.Side)
+	if err != nil {
+		return err
+	}
+
+	if !my.Accepts(order.units.price) {
+		return ErrPriceOutOfRange
+	}
+
+	return nil
+}
+
+// checkTimeInForce validates the order's time in force and sets its
+// expire time, if it has one.
+func (b *Book) checkTimeInForce(order *ClientOrder, now time.Time) error {
+	switch order.TimeInForce {
+	case TimeInForceGTC, TimeInForceIOC, TimeInForceFOK:
+		if !order.ExpireTime.IsZero() {
+			return ErrInvalidExpireTime
+		}
+	case TimeInForceGTD:
+		if IsMarket(order.Type) {
+			return ErrInvalidTimeInForce
+		}
+
+		if !order.ExpireTime.After(now) {
+			return ErrInvalidExpireTime
+		}
+	case TimeInForceDAY:
+		if IsMarket(order.Type) {
+			return ErrInvalidTimeInForce
+		}
+
+		if !order.ExpireTime.IsZero() {
+			return ErrInvalidExpireTime
+		}
+
+		// DAY orders live until the end of the UTC day they were
+		// submitted in.
+		const day = 24 * time.Hour
+		order.ExpireTime = now.UTC().Truncate(day).Add(day)
+	default:
+		return ErrInvalidTimeInForce
+	}
+
+	return nil
+}
+
+// execute turns the taker's matches into trades and saves them in the
+// trade history.  Prevented matches are returned separately.  The caller
+// must hold b.mu.
+func (b *Book) execute(taker ClientOrder, matches Matches, now time.Time) ([]Trade, []PreventedMatch) {
+	trades := make([]Trade, 0, len(matches))
+	prevented := make([]PreventedMatch, 0)
+
+	for _, match := range matches {
+		if match.Prevented {
+			x := PreventedMatch{
+				Symbol:      b.Symbol,
+				TakerID:     taker.ID,
+				MakerID:     b.clientID(match.MakerHandle),
+				TakerHandle: taker.Handle,
+				MakerHandle: match.MakerHandle,
+				Owner:       taker.Owner,
+				Price:       b.Instrument.Price(match.Price),
+				Quantity:    b.Instrument.Quantity(match.Quantity),
+				Mode:        taker.SelfTrade,
+				Sequence:    0,
+				Time:        now,
+				lots:        match.Quantity,
+			}
+
+			event := newEvent(EventSelfTradePrevented, now)
+			event.Prevented = &x
+			b.emit(event)
+
+			prevented = append(prevented, x)
+
+			continue
+		}
+
+		trade := Trade{
+			ID:          b.nextTradeID,
+			Symbol:      b.Symbol,
+			TakerID:     taker.ID,
+			MakerID:     b.clientID(match.MakerHandle),
+			TakerHandle: taker.Handle,
+			MakerHandle: match.MakerHandle,
+			Price:       b.Instrument.Price(match.Price),
+			Quantity:    b.Instrument.Quantity(match.Quantity),
+			Side:        taker.Side,
+			Sequence:    0,
+			Time:        now,
+			ticks:       match.Price,
+			lots:        match.Quantity,
+		}
+
+		event := newEvent(EventTrade, now)
+		event.Trade = &trade
+		b.emit(event)
+
+		trades = append(trades, trade)
+		b.nextTradeID++
+
+		b.addCandles(trade)
+		b.stats.add(trade)
+	}
+
+	b.trades = append(b.trades, trades...)
+	if n := len(b.trades); n > MaxTrades {
+		b.trades = b.trades[n-MaxTrades:]
+	}
+
+	return trades, prevented
+}
+
+// clientID returns the client ID of the order with the given handle.
+func (b *Book) clientID(handle uint64) string {
+	b.databaseMutex.Lock()
+	defer b.databaseMutex.Unlock()
+
+	order, ok := b.database[handle]
+	if !ok {
+		panic("illegal state")
+	}
+
+	return order.ID
+}
+
+// store saves the given order and updates the makers of its trades.  The
+// caller must hold b.mu.
+func (b *Book) store(order ClientOrder, trades []Trade, now time.Time) {
 	// Store new order.
 	b.databaseMutex.Lock()
-	b.database[order.ID] = order
+	order.VisibleQuantity = b.visibleQuantity(order)
+	b.save(order, now)
+
+	if IsFinal(order.State) {
+		b.unscheduleExpiry(order.Handle)
+	}
 
 	// Update matched orders.
-	for id, x := range matches {
-		maker, ok := b.database[id]
+	for _, trade := range trades {
+		maker, ok := b.database[trade.MakerHandle]
 		if !ok {
 			panic("illegal state")
 		}
 
-		maker.ExecutedQuantity = maker.ExecutedQuantity.Add(x)
-		b.database[maker.ID] = maker
+		if err := maker.fill(trade.Quantity, trade.lots); err != nil {
+			panic(err)
+		}
+
+		maker.VisibleQuantity = b.visibleQuantity(maker)
+		b.save(maker, now)
+
+		if maker.State == StateFilled {
+			b.unscheduleExpiry(maker.Handle)
+		}
 	}
 
 	b.databaseMutex.Unlock()
 }
 
-//nolint:cyclop
-func (b *Book) AddOrder(order ClientOrder) error {
-	if err := b.checkOrder(order); err != nil {
-		return err
+// prevent cancels or decrements the makers of the given prevented
+// matches, according to the taker's self-trade prevention mode.  The
+// caller must hold b.mu.
+func (b *Book) prevent(prevented []PreventedMatch, now time.Time) {
+	b.databaseMutex.Lock()
+	defer b.databaseMutex.Unlock()
+
+	for _, match := range prevented {
+		maker, ok := b.database[match.MakerHandle]
+		if !ok {
+			panic("illegal state")
+		}
+
+		var err error
+
+		switch match.Mode {
+		case SelfTradeCancelOldest, SelfTradeCancelBoth:
+			err = maker.transition(StateCanceled, ReasonSelfTrade)
+		case SelfTradeDecrement:
+			err = maker.decrement(match.Quantity, match.lots)
+		}
+
+		if err != nil {
+			panic(err)
+		}
+
+		maker.VisibleQuantity = b.visibleQuantity(maker)
+		b.save(maker, now)
+
+		if IsFinal(maker.State) {
+			b.unscheduleExpiry(maker.Handle)
+		}
+	}
+}
+
+// visibleQuantity returns how much of the given order is shown in the
+// book.  The caller must hold b.mu.
+func (b *Book) visibleQuantity(order ClientOrder) decimal.Decimal {
+	if order.State != StatePlaced && order.State != StatePartiallyFilled {
+		return decimal.Zero
+	}
+
+	ladder, price, err := b.ladderOf(order)
+	if err != nil {
+		panic(err)
+	}
+
+	if x, ok := ladder.GetOrder(price, order.Handle); ok {
+		return b.Instrument.Quantity(x.Quantity)
+	}
+
+	return decimal.Zero
+}
+
+// reject stores the order as rejected, so the reason can be queried
+// later.
+func (b *Book) reject(order ClientOrder, reason error, now time.Time) Report {
+	order.ExecutedQuantity = decimal.Zero
+
+	if err := order.transition(StateRejected, reason.Error()); err != nil {
+		panic(err)
+	}
+
+	b.store(order, nil, now)
+
+	return newReport(order, []Trade{}, []PreventedMatch{})
+}
+
+// expireOrders removes all resting orders whose expire time has passed.
+// It returns the IDs of the expired orders.  It only looks at those, so
+// it takes O(logN) per expired order.  The caller must hold b.mu.
+func (b *Book) expireOrders(now time.Time) []string {
+	expired := make([]string, 0)
+
+	for {
+		handle, ok := b.nextExpired(now)
+		if !ok {
+			break
+		}
+
+		b.databaseMutex.Lock()
+		order := b.database[handle]
+
+		if ladder, price, err := b.ladderOf(order); err == nil && ladder.RemoveOrder(price, handle) {
+			if err := order.transition(StateExpired, ReasonExpired); err != nil {
+				panic(err)
+			}
+
+			b.touch(ladder, price)
+			b.save(order, now)
+			expired = append(expired, order.ID)
+		}
+
+		b.databaseMutex.Unlock()
+	}
+
+	sort.Strings(expired)
+
+	return expired
+}
+
+// ExpireOrders removes all GTD and DAY orders whose expire time has
+// passed and returns their IDs.  Expired orders never get matched, even
+// if ExpireOrders is not called, but it should be called periodically so
+// they do not show up in snapshots.
+func (b *Book) ExpireOrders() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	defer b.emitLevels(now)
+
+	return b.expireOrders(now)
+}
+
+// AddOrder submits the given order, matches it against the opposite side
+// of the book and reports its resulting state along with its trades.
+// Orders that fail validation are rejected, but still get recorded under
+// their ID (unless the ID itself is invalid or taken).  Recorded orders
+// get a handle assigned, see ClientOrder.Handle.
+func (b *Book) AddOrder(order ClientOrder) (Report, error) {
+	if order.Symbol == "" {
+		order.Symbol = b.Symbol
+	}
+
+	order.State = StateInitial
+	order.LeavesQuantity = order.OriginalQuantity
+	order.PreventedQuantity = decimal.Zero
+	order.Reason = ""
+
+	// Validation takes decimal arithmetic, so it is done before locking
+	// the book.
+	invalid := b.checkOrder(&order)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.checkID(order.ID); err != nil {
+		return Report{}, err
+	}
+
+	order.Handle = atomic.AddUint64(b.lastHandle, 1)
+
+	now := b.now()
+	defer b.emitLevels(now)
+
+	// Expired orders must never get matched, so get rid of them first.
+	b.expireOrders(now)
+
+	if invalid == nil {
+		invalid = b.checkPlacement(&order, now)
+	}
+
+	if invalid != nil {
+		return b.reject(order, invalid, now), invalid
+	}
+
+	if !IsStop(order.Type) {
+		if err := b.checkPostOnly(&order, nil); err != nil {
+			return b.reject(order, err, now), err
+		}
+	}
+
+	// The order is accepted.
+	b.emitOrder(EventOrderAccepted, order, now)
+
+	var (
+		trades    []Trade
+		prevented []PreventedMatch
+	)
+
+	if IsStop(order.Type) {
+		// Stop orders wait for the last price to reach their stop price.
+		b.park(&order, now)
+
+		trades, prevented = make([]Trade, 0), make([]PreventedMatch, 0)
+	} else {
+		trades, prevented = b.match(&order, now)
 	}
 
+	// The trades may have moved the last price enough to trigger stop
+	// orders, possibly including this one.
+	triggeredTrades, triggeredPrevented := b.trigger(now)
+
+	for _, trade := range triggeredTrades {
+		if trade.TakerHandle == order.Handle {
+			trades = append(trades, trade)
+		}
+	}
+
+	for _, match := range triggeredPrevented {
+		if match.TakerHandle == order.Handle {
+			prevented = append(prevented, match)
+		}
+	}
+
+	b.databaseMutex.Lock()
+	order = b.database[order.Handle]
+	b.databaseMutex.Unlock()
+
+	report := newReport(order, trades, prevented)
+
+	switch {
+	case order.State == StatePendingTrigger:
+		// Not executed yet.
+	case order.TimeInForce == TimeInForceFOK && order.ExecutedQuantity.IsZero():
+		return report, ErrFillOrKillNotFilled
+	case IsMarket(order.Type) && order.State != StateFilled:
+		return report, ErrMarketOrderNotFullyExecuted
+	}
+
+	return report, nil
+}
+
+// match executes the given order against the opposite side of the book
+// and places whatever is left of it, if it is a limit order.  It stores
+// the order and returns its trades and prevented self-trades.  The
+// caller must hold b.mu.
+//
+//nolint:cyclop,funlen
+func (b *Book) match(order *ClientOrder, now time.Time) ([]Trade, []PreventedMatch) {
 	// We'll be matching this order against the opposite ladder, i.e. if
 	// this is a buy order, we'll try to match it first against the asks.
 	// If it's also a limit order and left unmatched, it will be added.
 	my, op, err := b.matchSides(order.Side)
 	if err != nil {
-		return err
+		panic(err)
 	}
 
-	x := NewOrder(order.ID, order.OriginalQuantity)
+	x := NewOrder(order.Handle, order.units.leaves())
+	x.Owner = order.Owner
+	x.SelfTrade = order.SelfTrade
 
 	var (
-		left    decimal.Decimal
+		left    int64
 		matches Matches
+		rests   bool
+		reason  string
 	)
 
-	switch order.Type {
-	case TypeMarket:
-		if !order.Price.IsZero() {
-			return ErrMarketOrderHasPrice
-		}
-
+	if IsMarket(order.Type) {
 		// Market orders get executed immediately against the orders we have in
 		// the order book.  If the market order is not fully executed, we return
 		// an error.
-		b.mu.Lock()
-		left, matches = op.MatchOrderMarket(x)
-		b.mu.Unlock()
-	case TypeLimit:
-		if order.Price.IsNegative() {
-			return ErrInvalidPrice
+		left, matches, reason = b.matchMarket(order, x, op)
+	} else {
+		// Limit orders may first be matched against the opposite side of the
+		// order book, sweeping all levels up to the limit price.  If the order
+		// remains not fully executed, it's placed in the order book, unless
+		// its time in force says otherwise.
+		price := order.units.price
+
+		if order.TimeInForce == TimeInForceFOK && op.Available(price, x) < x.Quantity {
+			left, matches, reason = x.Quantity, Matches{}, ReasonFillOrKill
+		} else {
+			left, matches = op.MatchOrderLimit(price, x)
+			reason = ReasonImmediateOrCancel
 		}
 
-		// Limit orders may first be matched against the opposite side of the
-		// order book.  If the order remains not fully executed, it's placed in
-		// the order book.
-		b.mu.Lock()
-		left, matches = op.MatchOrderLimit(order.Price, x)
+		rests = left > 0 && order.TimeInForce != TimeInForceIOC && order.TimeInForce != TimeInForceFOK
 
-		if left.IsPositive() {
-			my.AddOrder(order.Price, NewOrder(order.ID, left))
+		// Triggered stop orders were only checked against their stop
+		// ladder, see checkCapacity.
+		if rests && !my.Fits(left) {
+			rests, reason = false, ReasonOverflow
 		}
 
-		b.mu.Unlock()
+		if rests {
+			resting := NewIcebergOrder(order.Handle, left, order.units.display)
+			resting.Owner = order.Owner
+			my.AddOrder(price, resting)
+			b.touch(my, price)
+
+			if !order.ExpireTime.IsZero() {
+				b.scheduleExpiry(order.Handle, order.ExpireTime)
+			}
+		}
+	}
+
+	for _, match := range matches {
+		b.touch(op, match.Price)
+	}
+
+	trades, prevented := b.execute(*order, matches, now)
+
+	if executed := sumLots(trades); executed > 0 {
+		if err := order.fill(b.Instrument.Quantity(executed), executed); err != nil {
+			panic(err)
+		}
+	}
+
+	for _, match := range prevented {
+		switch match.Mode {
+		case SelfTradeCancelNewest, SelfTradeCancelBoth:
+			reason = ReasonSelfTrade
+		case SelfTradeDecrement:
+			if err := order.decrement(match.Quantity, match.lots); err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	switch {
+	case IsFinal(order.State):
+		// Nothing more to do.
+	case rests:
+		// Amended orders may be placed already.
+		if order.State == StateInitial || order.State == StatePendingTrigger {
+			err = order.transition(StatePlaced, "")
+		}
 	default:
-		return ErrInvalidType
+		// Whatever is left of the order gets canceled.
+		err = order.transition(StateCanceled, reason)
 	}
 
-	order.ExecutedQuantity = order.OriginalQuantity.Sub(left)
-	b.store(order, matches)
+	if err != nil {
+		panic(err)
+	}
+
+	b.store(*order, trades, now)
+	b.prevent(prevented, now)
+
+	return trades, prevented
+}
 
-	if order.Type == TypeMarket && order.ExecutedQuantity.LessThan(order.OriginalQuantity) {
-		return ErrMarketOrderNotFullyExecuted
+// matchMarket executes the given market order against the opposite
+// ladder, up to its protection price, if it has one.  Orders sized in
+// quote currency get their quantity calculated first.  Returns the order
+// quantity left unmatched and the reason it was left so.  The caller
+// must hold b.mu.
+func (b *Book) matchMarket(order *ClientOrder, x Order, op *Ladder) (int64, Matches, string) {
+	limit, limited := b.protectionPrice(*order, op, nil)
+
+	if order.QuoteQuantity.IsPositive() {
+		if limited {
+			x.Quantity = op.QuoteQuantity(limit, order.QuoteQuantity, b.Instrument)
+		} else {
+			x.Quantity = op.QuoteQuantityMarket(order.QuoteQuantity, b.Instrument)
+		}
+
+		order.units.quantity = x.Quantity
+		order.OriginalQuantity = b.Instrument.Quantity(x.Quantity)
+		order.LeavesQuantity = order.OriginalQuantity
 	}
 
-	return nil
+	var available int64
+
+	if limited {
+		available = op.Available(limit, x)
+	} else {
+		available = op.AvailableMarket(x)
+	}
+
+	// Fill-or-kill orders get executed either fully or not at all.
+	if order.TimeInForce == TimeInForceFOK && available < x.Quantity {
+		return x.Quantity, Matches{}, ReasonFillOrKill
+	}
+
+	if !limited {
+		left, matches := op.MatchOrderMarket(x)
+
+		return left, matches, ReasonMarket
+	}
+
+	left, matches := op.MatchOrderLimit(limit, x)
+	if left > 0 && op.Len() > 0 {
+		return left, matches, ReasonSlippage
+	}
+
+	return left, matches, ReasonMarket
+}
+
+// protectionPrice returns the worst price, in ticks, the given market
+// order may trade at, according to its max slippage from the touch.
+// Prices between ticks get rounded towards the touch.  Makers for which
+// live returns false are skipped, see Ladder.sweep.
+func (b *Book) protectionPrice(order ClientOrder, op *Ladder, live func(maker *Order) bool) (int64, bool) {
+	if order.MaxSlippage.IsZero() {
+		return 0, false
+	}
+
+	best := op.best(live)
+	if best == nil {
+		return 0, false
+	}
+
+	touch := decimal.NewFromInt(best.Price)
+
+	switch order.Side {
+	case SideBuy:
+		limit := touch.Mul(decimal.NewFromInt(1).Add(order.MaxSlippage)).Floor()
+
+		return decimal.Min(limit, decimal.NewFromInt(MaxUnits)).IntPart(), true
+	case SideSell:
+		return touch.Mul(decimal.NewFromInt(1).Sub(order.MaxSlippage)).Ceil().IntPart(), true
+	default:
+		panic("illegal side")
+	}
+}
+
+func sumLots(trades []Trade) int64 {
+	var ans int64
+
+	for _, trade := range trades {
+		ans += trade.lots
+	}
+
+	return ans
 }
 
 func (b *Book) CancelOrder(id string) error {
@@ -167,11 +1102,17 @@ func (b *Book) CancelOrder(id string) error {
 		return ErrInvalidID
 	}
 
-	// Check if order exists.
-	b.databaseMutex.Lock()
-	order, ok := b.database[id]
-	b.databaseMutex.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
 
+	now := b.now()
+	defer b.emitLevels(now)
+
+	// Orders past their expire time cannot be canceled anymore.
+	b.expireOrders(now)
+
+	// Check if order exists.
+	order, ok := b.lookup(id)
 	if !ok {
 		return ErrOrderDoesNotExist
 	}
@@ -179,37 +1120,55 @@ func (b *Book) CancelOrder(id string) error {
 	// Check the order type.
 	if order.Type == TypeMarket {
 		return ErrCannotCancelMarketOrder
-	} else if order.Type != TypeLimit {
-		return ErrInvalidType
+	}
+
+	// Check the order state.
+	switch {
+	case order.State == StateCanceled:
+		return ErrOrderAlreadyCanceled
+	case order.State == StateFilled:
+		return ErrOrderAlreadyFilled
+	case !CanTransition(order.State, StateCanceled):
+		return ErrCannotCancelOrder
 	}
 
 	// Actually try to remove the order.
-	switch order.Side {
-	case SideBuy:
-		b.mu.Lock()
-		defer b.mu.Unlock()
+	ladder, price, err := b.ladderOf(order)
+	if err != nil {
+		return err
+	}
 
-		if b.Bids.RemoveOrder(order.Price, order.ID) {
-			return nil
-		}
-	case SideSell:
-		b.mu.Lock()
-		defer b.mu.Unlock()
+	if !ladder.RemoveOrder(price, order.Handle) {
+		// At this point this order was not eligible for cancellation.
+		return ErrCannotCancelOrder
+	}
 
-		if b.Asks.RemoveOrder(order.Price, order.ID) {
-			return nil
-		}
-	default:
-		return ErrInvalidSide
+	b.unscheduleExpiry(order.Handle)
+	b.touch(ladder, price)
+
+	if err := order.transition(StateCanceled, ReasonCanceled); err != nil {
+		panic(err)
 	}
 
-	// At this point this order was not eligible for cancellation.
-	return ErrCannotCancelOrder
+	b.store(order, nil, now)
+
+	return nil
 }
 
 func (b *Book) GetOrder(id string) (ClientOrder, error) {
+	order, ok := b.lookup(id)
+	if !ok {
+		return order, ErrOrderDoesNotExist
+	}
+
+	return order, nil
+}
+
+// GetOrderByHandle returns the order with the given handle, see
+// ClientOrder.Handle.
+func (b *Book) GetOrderByHandle(handle uint64) (ClientOrder, error) {
 	b.databaseMutex.Lock()
-	order, ok := b.database[id]
+	order, ok := b.database[handle]
 	b.databaseMutex.Unlock()
 
 	if !ok {
@@ -219,10 +1178,36 @@ func (b *Book) GetOrder(id string) (ClientOrder, error) {
 	return order, nil
 }
 
+// GetTrades returns up to limit of the most recent trades, oldest first.
+// A non-positive limit returns the whole trade history kept, see
+// MaxTrades.
+func (b *Book) GetTrades(limit int) []Trade {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	trades := b.trades
+	if limit > 0 && limit < len(trades) {
+		trades = trades[len(trades)-limit:]
+	}
+
+	ans := make([]Trade, len(trades))
+	copy(ans, trades)
+
+	return ans
+}
+
 func (b *Book) GetSnapshot(depth int) Snapshot {
+	if depth < 0 {
+		depth = 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	ans := Snapshot{
-		Asks: make([]ClientLevel, 0, depth),
-		Bids: make([]ClientLevel, 0, depth),
+		Asks:     make([]ClientLevel, 0, minInt(depth, b.Asks.Len())),
+		Bids:     make([]ClientLevel, 0, minInt(depth, b.Bids.Len())),
+		Sequence: b.sequence,
 	}
 
 	askDepth := 0
@@ -232,10 +1217,7 @@ func (b *Book) GetSnapshot(depth int) Snapshot {
 		}
 		askDepth++
 
-		ans.Asks = append(ans.Asks, ClientLevel{
-			Price:    level.Price,
-			Quantity: level.TotalQuantity(),
-		})
+		ans.Asks = append(ans.Asks, b.clientLevel(level))
 
 		return true
 	}
@@ -247,18 +1229,90 @@ func (b *Book) GetSnapshot(depth int) Snapshot {
 		}
 		bidDepth++
 
-		ans.Bids = append(ans.Bids, ClientLevel{
-			Price:    level.Price,
-			Quantity: level.TotalQuantity(),
-		})
+		ans.Bids = append(ans.Bids, b.clientLevel(level))
 
 		return true
 	}
 
-	b.mu.Lock()
 	b.Asks.Walk(ask)
 	b.Bids.Walk(bid)
-	b.mu.Unlock()
 
 	return ans
 }
+
+// GetL3Snapshot returns up to depth levels of each side of the book along
+// with their resting orders.  Hidden quantities of iceberg orders are not
+// shown.
+func (b *Book) GetL3Snapshot(depth int) L3Snapshot {
+	if depth < 0 {
+		depth = 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.databaseMutex.Lock()
+	defer b.databaseMutex.Unlock()
+
+	return L3Snapshot{
+		Asks:     b.l3Levels(&b.Asks, depth),
+		Bids:     b.l3Levels(&b.Bids, depth),
+		Sequence: b.sequence,
+	}
+}
+
+// l3Levels converts up to depth levels of the given ladder for clients.
+// The caller must hold b.mu and b.databaseMutex.
+func (b *Book) l3Levels(ladder *Ladder, depth int) []ClientL3Level {
+	ans := make([]ClientL3Level, 0, minInt(depth, ladder.Len()))
+
+	ladder.Walk(func(level *Level) bool {
+		if len(ans) >= depth {
+			return false
+		}
+
+		orders := make([]ClientRestingOrder, 0, level.Orders.Len())
+		for _, order := range level.Orders.Iter() {
+			orders = append(orders, ClientRestingOrder{
+				ID:        b.database[order.Handle].ID,
+				Handle:    order.Handle,
+				Quantity:  b.Instrument.Quantity(order.Quantity),
+				Insertion: order.InsertionIndex,
+			})
+		}
+
+		ans = append(ans, ClientL3Level{
+			Price:    b.Instrument.Price(level.Price),
+			Quantity: b.Instrument.Quantity(level.TotalQuantity()),
+			Orders:   orders,
+		})
+
+		return true
+	})
+
+	return ans
+}
+
+// clientLevel converts the given level for clients.
+func (b *Book) clientLevel(level *Level) ClientLevel {
+	return ClientLevel{
+		Price:    b.Instrument.Price(level.Price),
+		Quantity: b.Instrument.Quantity(level.TotalQuantity()),
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
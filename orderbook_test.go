@@ -3,8 +3,10 @@ package orderbook_test
 import (
 	"errors"
 	"fmt"
+	"math/rand"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 	"github.com/ydm/orderbook"
@@ -17,18 +19,22 @@ type pq struct{ price, quantity string }
 func assertCountLevels(t *testing.T, book *orderbook.Book, asks, bids int) {
 	t.Helper()
 
-	if have := book.Asks.Heap.CountLevels(); have != asks {
+	if have := book.Asks.Len(); have != asks {
 		t.Errorf("have %d, want %d", have, asks)
 	}
 
-	if have := book.Bids.Heap.CountLevels(); have != bids {
+	if have := book.Bids.Len(); have != bids {
 		t.Errorf("have %d, want %d", have, bids)
 	}
 }
 
+// assertLevels checks the levels of a ladder of a book trading the
+// default instrument.
 func assertLevels(t *testing.T, ladder *orderbook.Ladder, expected ...pq) {
 	t.Helper()
 
+	instrument := orderbook.DefaultInstrument()
+
 	ladder.Walk(func(level *orderbook.Level) bool {
 		t.Helper()
 
@@ -49,11 +55,11 @@ func assertLevels(t *testing.T, ladder *orderbook.Ladder, expected ...pq) {
 			panic(err)
 		}
 
-		if havePrice := level.Price; !havePrice.Equal(wantPrice) {
+		if havePrice := instrument.Price(level.Price); !havePrice.Equal(wantPrice) {
 			t.Errorf("have %v, want %v", havePrice, wantPrice)
 		}
 
-		if haveQuantity := level.TotalQuantity(); !haveQuantity.Equal(wantQuantity) {
+		if haveQuantity := instrument.Quantity(level.TotalQuantity()); !haveQuantity.Equal(wantQuantity) {
 			t.Errorf("have %v, want %v", haveQuantity, wantQuantity)
 		}
 
@@ -82,6 +88,28 @@ func assertExecutedQuantities(t *testing.T, b *orderbook.Book, expected ...iq) {
 	}
 }
 
+func submit(t *testing.T, b *orderbook.Book, order orderbook.ClientOrder) orderbook.Report {
+	t.Helper()
+
+	report, err := b.AddOrder(order)
+	if err != nil {
+		t.Errorf("order %s: %v", order.ID, err)
+	}
+
+	return report
+}
+
+func limitOrder(id string, side int, price, quantity int64) orderbook.ClientOrder {
+	return orderbook.ClientOrder{
+		Side:             side,
+		OriginalQuantity: decimal.NewFromInt(quantity),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(price),
+		ID:               id,
+		Type:             orderbook.TypeLimit,
+	}
+}
+
 // Submit a market order against an empty order book.
 func TestBook_AddOrder_1(t *testing.T) {
 	t.Parallel()
@@ -100,7 +128,7 @@ func TestBook_AddOrder_1(t *testing.T) {
 
 	// Make sure market orders do not end up in the order book, but rather get matched
 	// against what's in the book.
-	err := b.AddOrder(order)
+	_, err := b.AddOrder(order)
 	assertCountLevels(t, b, 0, 0)
 
 	// Since the book is empty, the error returned should notify of incomplete
@@ -139,7 +167,7 @@ func TestBook_AddOrder_2(t *testing.T) {
 	}
 
 	// Make sure limit orders get added to the order book.
-	if err := b.AddOrder(limit); err != nil {
+	if _, err := b.AddOrder(limit); err != nil {
 		t.Error(err)
 	}
 
@@ -147,7 +175,7 @@ func TestBook_AddOrder_2(t *testing.T) {
 	assertLevels(t, &b.Asks, pq{"10000", "2"})
 
 	// Make sure the same order cannot be submitted twice.
-	if err := b.AddOrder(limit); !errors.Is(err, orderbook.ErrOrderExists) {
+	if _, err := b.AddOrder(limit); !errors.Is(err, orderbook.ErrOrderExists) {
 		t.Error()
 	}
 
@@ -156,7 +184,7 @@ func TestBook_AddOrder_2(t *testing.T) {
 
 	// Make sure this market gets matched and what's left in the order book is the
 	// partially executed limit order.
-	if err := b.AddOrder(market); err != nil {
+	if _, err := b.AddOrder(market); err != nil {
 		t.Error(err)
 	}
 
@@ -179,7 +207,7 @@ func TestBook_AddOrder_3(t *testing.T) {
 		Type:             orderbook.TypeLimit,
 	}
 
-	if err := b.AddOrder(limit); err != nil {
+	if _, err := b.AddOrder(limit); err != nil {
 		t.Error(err)
 	}
 
@@ -191,7 +219,7 @@ func TestBook_AddOrder_3(t *testing.T) {
 		ID:               "market",
 		Type:             orderbook.TypeMarket,
 	}
-	err := b.AddOrder(market)
+	_, err := b.AddOrder(market)
 
 	// Make sure the order book is now empty.
 	assertCountLevels(t, b, 0, 0)
@@ -221,7 +249,7 @@ func TestBook_AddOrder_4(t *testing.T) {
 		Type:             orderbook.TypeLimit,
 	}
 
-	if err := b.AddOrder(sell); err != nil {
+	if _, err := b.AddOrder(sell); err != nil {
 		t.Error(err)
 	}
 
@@ -236,7 +264,7 @@ func TestBook_AddOrder_4(t *testing.T) {
 		Type:             orderbook.TypeLimit,
 	}
 
-	if err := b.AddOrder(buy); err != nil {
+	if _, err := b.AddOrder(buy); err != nil {
 		t.Error(err)
 	}
 
@@ -258,7 +286,7 @@ func TestBook_AddOrder_5(t *testing.T) {
 		Type:             orderbook.TypeLimit,
 	}
 
-	if err := b.AddOrder(sell); err != nil {
+	if _, err := b.AddOrder(sell); err != nil {
 		t.Error(err)
 	}
 
@@ -274,7 +302,7 @@ func TestBook_AddOrder_5(t *testing.T) {
 		Type:             orderbook.TypeLimit,
 	}
 
-	if err := b.AddOrder(buy); err != nil {
+	if _, err := b.AddOrder(buy); err != nil {
 		t.Error(err)
 	}
 
@@ -322,7 +350,7 @@ func TestBook_AddOrder_6(t *testing.T) {
 				t.Error(priceErr)
 			}
 
-			if err := book.AddOrder(orderbook.ClientOrder{
+			if _, err := book.AddOrder(orderbook.ClientOrder{
 				Side:             orderbook.SideBuy,
 				OriginalQuantity: quantity,
 				ExecutedQuantity: decimal.Zero,
@@ -360,7 +388,7 @@ func TestBook_AddOrder_6(t *testing.T) {
 		expectedQuantity97 int,
 	) {
 		book := setup()
-		submissionError := book.AddOrder(orderbook.ClientOrder{
+		_, submissionError := book.AddOrder(orderbook.ClientOrder{
 			Side:             orderbook.SideSell,
 			OriginalQuantity: decimal.NewFromInt(quantity),
 			ExecutedQuantity: decimal.Zero,
@@ -422,6 +450,81 @@ func TestBook_AddOrder_6(t *testing.T) {
 	check(8, 6, 0, 0, 0, 3, 2, 1)
 }
 
+// A limit order that crosses the spread sweeps several levels at the
+// makers' prices and rests only its remainder.
+func TestBook_AddOrder_7(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+
+	for _, x := range []pq{{"100", "1"}, {"101", "2"}, {"106", "3"}} {
+		if _, err := b.AddOrder(orderbook.ClientOrder{
+			Side:             orderbook.SideSell,
+			OriginalQuantity: decimal.RequireFromString(x.quantity),
+			ExecutedQuantity: decimal.Zero,
+			Price:            decimal.RequireFromString(x.price),
+			ID:               "sell" + x.price,
+			Type:             orderbook.TypeLimit,
+		}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(5),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(105),
+		ID:               "buy",
+		Type:             orderbook.TypeLimit,
+	}); err != nil {
+		t.Error(err)
+	}
+
+	assertCountLevels(t, b, 1, 1)
+	assertLevels(t, &b.Asks, pq{"106", "3"})
+	assertLevels(t, &b.Bids, pq{"105", "2"})
+	assertExecutedQuantities(t, b,
+		iq{"sell100", "1"},
+		iq{"sell101", "2"},
+		iq{"sell106", "0"},
+		iq{"buy", "3"},
+	)
+}
+
+// Submit lots of overlapping limit orders and make sure the book never
+// ends up locked (best bid == best ask) or crossed (best bid > best ask).
+func TestBook_AddOrder_8(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	r := rand.New(rand.NewSource(1)) //nolint:gosec
+
+	for i := 0; i < 2000; i++ {
+		side := orderbook.SideBuy
+		if r.Intn(2) == 0 {
+			side = orderbook.SideSell
+		}
+
+		if _, err := b.AddOrder(orderbook.ClientOrder{
+			Side:             side,
+			OriginalQuantity: decimal.NewFromInt(int64(1 + r.Intn(10))),
+			ExecutedQuantity: decimal.Zero,
+			Price:            decimal.NewFromInt(int64(90 + r.Intn(21))),
+			ID:               strconv.Itoa(i),
+			Type:             orderbook.TypeLimit,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot := b.GetSnapshot(1)
+		if len(snapshot.Asks) > 0 && len(snapshot.Bids) > 0 &&
+			snapshot.Bids[0].Price.GreaterThanOrEqual(snapshot.Asks[0].Price) {
+			t.Fatalf("order %d: best bid %v >= best ask %v", i, snapshot.Bids[0].Price, snapshot.Asks[0].Price)
+		}
+	}
+}
+
 func TestBook_CancelOrder_1(t *testing.T) {
 	t.Parallel()
 
@@ -444,7 +547,7 @@ func TestBook_CancelOrder_1(t *testing.T) {
 		Type:             orderbook.TypeMarket,
 	}
 
-	if err := b.AddOrder(market); !errors.Is(err, orderbook.ErrMarketOrderNotFullyExecuted) {
+	if _, err := b.AddOrder(market); !errors.Is(err, orderbook.ErrMarketOrderNotFullyExecuted) {
 		t.Error()
 	}
 
@@ -467,7 +570,7 @@ func TestBook_CancelOrder_2(t *testing.T) {
 		Type:             orderbook.TypeLimit,
 	}
 
-	if err := b.AddOrder(limit); err != nil {
+	if _, err := b.AddOrder(limit); err != nil {
 		t.Error(err)
 	}
 
@@ -502,19 +605,19 @@ func TestBook_CancelOrder_3(t *testing.T) {
 		Type:             orderbook.TypeMarket,
 	}
 
-	if err := b.AddOrder(limit); err != nil {
+	if _, err := b.AddOrder(limit); err != nil {
 		t.Error(err)
 	}
 
 	assertCountLevels(t, b, 1, 0)
 
-	if err := b.AddOrder(market); err != nil {
+	if _, err := b.AddOrder(market); err != nil {
 		t.Error(err)
 	}
 
 	assertCountLevels(t, b, 0, 0)
 
-	if err := b.CancelOrder("limit"); !errors.Is(err, orderbook.ErrCannotCancelOrder) {
+	if err := b.CancelOrder("limit"); !errors.Is(err, orderbook.ErrOrderAlreadyFilled) {
 		t.Error(err)
 	}
 }
@@ -540,7 +643,7 @@ func TestBook_GetSnapshot(t *testing.T) {
 				order.Side = orderbook.SideSell
 			}
 
-			if err := b.AddOrder(order); err != nil {
+			if _, err := b.AddOrder(order); err != nil {
 				t.Error(err)
 			}
 		}
@@ -597,3 +700,1144 @@ func TestBook_GetSnapshot(t *testing.T) {
 	assertEq(snapshot.Bids[8], 12)
 	assertEq(snapshot.Bids[9], 11)
 }
+
+func TestBook_GetL3Snapshot(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	iceberg := limitOrder("iceberg", orderbook.SideSell, 100, 10)
+	iceberg.DisplayQuantity = decimal.NewFromInt(3)
+
+	submit(t, b, limitOrder("first", orderbook.SideSell, 100, 2))
+	submit(t, b, iceberg)
+	submit(t, b, limitOrder("last", orderbook.SideSell, 100, 1))
+	submit(t, b, limitOrder("far", orderbook.SideSell, 101, 5))
+	submit(t, b, limitOrder("bid", orderbook.SideBuy, 99, 4))
+	submit(t, b, limitOrder("taker", orderbook.SideBuy, 100, 1))
+
+	snapshot := b.GetL3Snapshot(1)
+	if len(snapshot.Asks) != 1 || len(snapshot.Bids) != 1 {
+		t.Fatalf("have %d and %d levels, want 1", len(snapshot.Asks), len(snapshot.Bids))
+	}
+
+	if snapshot.Sequence != b.GetSnapshot(0).Sequence {
+		t.Errorf("have sequence %d, want %d", snapshot.Sequence, b.GetSnapshot(0).Sequence)
+	}
+
+	level := snapshot.Asks[0]
+	if !level.Price.Equal(decimal.NewFromInt(100)) || !level.Quantity.Equal(decimal.NewFromInt(5)) {
+		t.Errorf("have %v@%v, want 5@100", level.Quantity, level.Price)
+	}
+
+	// Orders come in execution order, icebergs show their visible quantity
+	// only.
+	expected := []iq{{"first", "1"}, {"iceberg", "3"}, {"last", "1"}}
+	if len(level.Orders) != len(expected) {
+		t.Fatalf("have %d orders, want %d", len(level.Orders), len(expected))
+	}
+
+	for i, order := range level.Orders {
+		if order.ID != expected[i].id || order.Quantity.String() != expected[i].quantity {
+			t.Errorf("have %s %v, want %s %s", order.ID, order.Quantity, expected[i].id, expected[i].quantity)
+		}
+
+		if i > 0 && order.Insertion <= level.Orders[i-1].Insertion {
+			t.Errorf("have insertion %d after %d", order.Insertion, level.Orders[i-1].Insertion)
+		}
+	}
+
+	if bid := snapshot.Bids[0]; len(bid.Orders) != 1 || bid.Orders[0].ID != "bid" {
+		t.Errorf("have %v, want bid", bid.Orders)
+	}
+
+	if snapshot := b.GetL3Snapshot(10); len(snapshot.Asks) != 2 || len(snapshot.Bids) != 1 {
+		t.Errorf("have %d and %d levels, want 2 and 1", len(snapshot.Asks), len(snapshot.Bids))
+	}
+}
+
+func TestBook_QueuePosition(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	submit(t, b, limitOrder("first", orderbook.SideSell, 100, 2))
+	submit(t, b, limitOrder("second", orderbook.SideSell, 100, 3))
+	submit(t, b, limitOrder("third", orderbook.SideSell, 100, 1))
+	submit(t, b, limitOrder("fourth", orderbook.SideSell, 100, 4))
+
+	assertPosition := func(id string, index int, ahead, total int64) {
+		t.Helper()
+
+		position, err := b.QueuePosition(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !position.Price.Equal(decimal.NewFromInt(100)) {
+			t.Errorf("have price %v, want 100", position.Price)
+		}
+
+		if position.Index != index || !position.Ahead.Equal(decimal.NewFromInt(ahead)) ||
+			!position.Total.Equal(decimal.NewFromInt(total)) {
+			t.Errorf("have %d %v %v, want %d %d %d", position.Index, position.Ahead, position.Total, index, ahead, total)
+		}
+	}
+
+	assertPosition("third", 2, 5, 10)
+
+	// Partial fills and cancels of orders ahead move the order forward.
+	submit(t, b, limitOrder("taker", orderbook.SideBuy, 100, 1))
+	assertPosition("third", 2, 4, 9)
+
+	if err := b.CancelOrder("second"); err != nil {
+		t.Error(err)
+	}
+
+	assertPosition("first", 0, 0, 6)
+	assertPosition("third", 1, 1, 6)
+	assertPosition("fourth", 2, 2, 6)
+
+	if _, err := b.QueuePosition("taker"); !errors.Is(err, orderbook.ErrOrderNotResting) {
+		t.Errorf("have %v, want ErrOrderNotResting", err)
+	}
+
+	if _, err := b.QueuePosition("fifth"); !errors.Is(err, orderbook.ErrOrderDoesNotExist) {
+		t.Errorf("have %v, want ErrOrderDoesNotExist", err)
+	}
+}
+
+func assertStates(t *testing.T, b *orderbook.Book, expected map[string]int) {
+	t.Helper()
+
+	for id, state := range expected {
+		order, err := b.GetOrder(id)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if order.State != state {
+			t.Errorf("order %s: have state %d, want state %d", id, order.State, state)
+		}
+	}
+}
+
+func newTimeInForceBook(t *testing.T, now func() time.Time) *orderbook.Book {
+	t.Helper()
+
+	b := orderbook.NewBook(orderbook.WithClock(now))
+
+	for _, x := range []pq{{"100", "1"}, {"101", "2"}} {
+		if _, err := b.AddOrder(orderbook.ClientOrder{
+			Side:             orderbook.SideSell,
+			OriginalQuantity: decimal.RequireFromString(x.quantity),
+			ExecutedQuantity: decimal.Zero,
+			Price:            decimal.RequireFromString(x.price),
+			ID:               "sell" + x.price,
+			Type:             orderbook.TypeLimit,
+		}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	return b
+}
+
+// Immediate-or-cancel orders fill what they can and never rest.
+func TestBook_AddOrder_IOC(t *testing.T) {
+	t.Parallel()
+
+	b := newTimeInForceBook(t, time.Now)
+
+	if _, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(2),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(100),
+		ID:               "ioc",
+		Type:             orderbook.TypeLimit,
+		TimeInForce:      orderbook.TimeInForceIOC,
+	}); err != nil {
+		t.Error(err)
+	}
+
+	assertCountLevels(t, b, 1, 0)
+	assertExecutedQuantities(t, b, iq{"ioc", "1"}, iq{"sell100", "1"})
+	assertStates(t, b, map[string]int{
+		"ioc":     orderbook.StateCanceled,
+		"sell100": orderbook.StateFilled,
+		"sell101": orderbook.StatePlaced,
+	})
+}
+
+// Fill-or-kill orders check the liquidity across all levels before
+// touching the book.
+func TestBook_AddOrder_FOK(t *testing.T) {
+	t.Parallel()
+
+	b := newTimeInForceBook(t, time.Now)
+
+	// There is only 3 available up to 101.
+	_, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(4),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(101),
+		ID:               "fok1",
+		Type:             orderbook.TypeLimit,
+		TimeInForce:      orderbook.TimeInForceFOK,
+	})
+	if !errors.Is(err, orderbook.ErrFillOrKillNotFilled) {
+		t.Errorf("have %v, want ErrFillOrKillNotFilled", err)
+	}
+
+	assertLevels(t, &b.Asks, pq{"100", "1"}, pq{"101", "2"})
+	assertExecutedQuantities(t, b, iq{"fok1", "0"})
+	assertStates(t, b, map[string]int{"fok1": orderbook.StateCanceled})
+
+	_, err = b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(4),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.Zero,
+		ID:               "fok2",
+		Type:             orderbook.TypeMarket,
+		TimeInForce:      orderbook.TimeInForceFOK,
+	})
+	if !errors.Is(err, orderbook.ErrFillOrKillNotFilled) {
+		t.Errorf("have %v, want ErrFillOrKillNotFilled", err)
+	}
+
+	assertLevels(t, &b.Asks, pq{"100", "1"}, pq{"101", "2"})
+
+	if _, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(3),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(101),
+		ID:               "fok3",
+		Type:             orderbook.TypeLimit,
+		TimeInForce:      orderbook.TimeInForceFOK,
+	}); err != nil {
+		t.Error(err)
+	}
+
+	assertCountLevels(t, b, 0, 0)
+	assertExecutedQuantities(t, b, iq{"fok3", "3"})
+	assertStates(t, b, map[string]int{"fok3": orderbook.StateFilled})
+}
+
+// Good-till-date and day orders expire and never get matched afterwards.
+func TestBook_AddOrder_GTD(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newTimeInForceBook(t, func() time.Time { return now })
+
+	gtd := orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(99),
+		ID:               "rejected",
+		Type:             orderbook.TypeLimit,
+		TimeInForce:      orderbook.TimeInForceGTD,
+		ExpireTime:       now,
+	}
+
+	// Expire time must be in the future.
+	if _, err := b.AddOrder(gtd); !errors.Is(err, orderbook.ErrInvalidExpireTime) {
+		t.Errorf("have %v, want ErrInvalidExpireTime", err)
+	}
+
+	gtd.ID = "gtd"
+	gtd.ExpireTime = now.Add(time.Hour)
+	if _, err := b.AddOrder(gtd); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(98),
+		ID:               "day",
+		Type:             orderbook.TypeLimit,
+		TimeInForce:      orderbook.TimeInForceDAY,
+	}); err != nil {
+		t.Error(err)
+	}
+
+	assertCountLevels(t, b, 2, 2)
+
+	if day, _ := b.GetOrder("day"); !day.ExpireTime.Equal(time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("have %v, want end of day", day.ExpireTime)
+	}
+
+	now = now.Add(time.Hour)
+
+	if have := b.ExpireOrders(); len(have) != 1 || have[0] != "gtd" {
+		t.Errorf("have %v, want [gtd]", have)
+	}
+
+	assertCountLevels(t, b, 2, 1)
+
+	// The day order expires before a market sell gets a chance to match
+	// it.
+	now = now.Add(12 * time.Hour)
+
+	_, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideSell,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.Zero,
+		ID:               "market",
+		Type:             orderbook.TypeMarket,
+	})
+	if !errors.Is(err, orderbook.ErrMarketOrderNotFullyExecuted) {
+		t.Errorf("have %v, want ErrMarketOrderNotFullyExecuted", err)
+	}
+
+	assertCountLevels(t, b, 2, 0)
+	assertStates(t, b, map[string]int{
+		"rejected": orderbook.StateRejected,
+		"gtd":      orderbook.StateExpired,
+		"day":      orderbook.StateExpired,
+		"market":   orderbook.StateCanceled,
+	})
+}
+
+// Orders expire in the order of their expire times, canceled and filled
+// ones never do and amended ones only once.
+func TestBook_ExpireOrders(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := orderbook.NewBook(orderbook.WithClock(func() time.Time { return now }))
+
+	for i := 1; i <= 6; i++ {
+		order := limitOrder("gtd"+strconv.Itoa(i), orderbook.SideBuy, int64(100+i), 1)
+		order.TimeInForce = orderbook.TimeInForceGTD
+		order.ExpireTime = now.Add(time.Duration(7-i) * time.Minute)
+
+		submit(t, b, order)
+	}
+
+	if err := b.CancelOrder("gtd6"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := b.AmendOrder("gtd5", decimal.NewFromInt(99), decimal.Zero); err != nil {
+		t.Error(err)
+	}
+
+	submit(t, b, limitOrder("sell", orderbook.SideSell, 104, 1))
+
+	now = now.Add(2 * time.Minute)
+
+	if have := fmt.Sprint(b.ExpireOrders()); have != "[gtd5]" {
+		t.Errorf("have %s, want [gtd5]", have)
+	}
+
+	now = now.Add(time.Hour)
+
+	if have := fmt.Sprint(b.ExpireOrders()); have != "[gtd1 gtd2 gtd3]" {
+		t.Errorf("have %s, want [gtd1 gtd2 gtd3]", have)
+	}
+
+	if have := b.ExpireOrders(); len(have) != 0 {
+		t.Errorf("have %v, want []", have)
+	}
+
+	assertCountLevels(t, b, 0, 0)
+	assertStates(t, b, map[string]int{
+		"gtd1": orderbook.StateExpired,
+		"gtd4": orderbook.StateFilled,
+		"gtd5": orderbook.StateExpired,
+		"gtd6": orderbook.StateCanceled,
+	})
+}
+
+// Each iteration adds and cancels an order next to many resting day
+// orders, none of them expired.
+func BenchmarkBook_AddOrder_DayOrders(b *testing.B) {
+	const resting = 50000
+
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	book := orderbook.NewBook(orderbook.WithClock(func() time.Time { return now }))
+
+	for i := 0; i < resting; i++ {
+		order := limitOrder("day"+strconv.Itoa(i), orderbook.SideBuy, int64(1+i%1000), 1)
+		order.TimeInForce = orderbook.TimeInForceDAY
+
+		if _, err := book.AddOrder(order); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		id := "sell" + strconv.Itoa(i)
+
+		if _, err := book.AddOrder(limitOrder(id, orderbook.SideSell, 2000, 1)); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := book.CancelOrder(id); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Each iteration crosses the best of 1000 resting asks with a buy limit
+// order, the way a busy book gets hit.
+func BenchmarkBook_AddOrder(b *testing.B) {
+	const resting = 1000
+
+	book := orderbook.NewBook()
+
+	for i := 0; i < resting; i++ {
+		if _, err := book.AddOrder(limitOrder("sell"+strconv.Itoa(i), orderbook.SideSell, int64(100+i), 1e6)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	ids := make([]string, b.N)
+	for i := range ids {
+		ids[i] = "buy" + strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := book.AddOrder(limitOrder(ids[i], orderbook.SideBuy, 100, 1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Every fill is reported as a trade at the maker's price and saved in
+// the trade history.
+func TestBook_AddOrder_Trades(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newTimeInForceBook(t, func() time.Time { return now })
+
+	report, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(4),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(105),
+		ID:               "buy",
+		Type:             orderbook.TypeLimit,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if report.Order.ID != "buy" || !report.Order.ExecutedQuantity.Equal(decimal.NewFromInt(3)) {
+		t.Errorf("unexpected order %v", report.Order)
+	}
+
+	want := []struct {
+		id       int64
+		maker    string
+		price    string
+		quantity string
+	}{
+		{1, "sell100", "100", "1"},
+		{2, "sell101", "101", "2"},
+	}
+
+	if len(report.Trades) != len(want) {
+		t.Fatalf("have %d trades, want %d", len(report.Trades), len(want))
+	}
+
+	for i, trade := range report.Trades {
+		if trade.ID != want[i].id || trade.TakerID != "buy" || trade.MakerID != want[i].maker ||
+			!trade.Price.Equal(decimal.RequireFromString(want[i].price)) ||
+			!trade.Quantity.Equal(decimal.RequireFromString(want[i].quantity)) ||
+			trade.Side != orderbook.SideBuy || !trade.Time.Equal(now) {
+			t.Errorf("unexpected trade %v", trade)
+		}
+
+		if i > 0 && trade.Sequence <= report.Trades[i-1].Sequence {
+			t.Errorf("have sequence %d after %d", trade.Sequence, report.Trades[i-1].Sequence)
+		}
+	}
+
+	if have := b.GetTrades(0); len(have) != 2 {
+		t.Errorf("have %d trades, want 2", len(have))
+	}
+
+	if have := b.GetTrades(1); len(have) != 1 || have[0].ID != 2 {
+		t.Errorf("have %v, want trade 2", have)
+	}
+}
+
+func TestBook_GetTrades_Retention(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	n := orderbook.MaxTrades + 5
+
+	for i := 0; i < n; i++ {
+		submit(t, b, limitOrder("sell"+strconv.Itoa(i), orderbook.SideSell, 100, 1))
+	}
+
+	if report := submit(t, b, marketOrder("buy", orderbook.SideBuy, int64(n))); len(report.Trades) != n {
+		t.Errorf("have %d trades, want %d", len(report.Trades), n)
+	}
+
+	// The oldest trades got dropped.
+	trades := b.GetTrades(0)
+	if len(trades) != orderbook.MaxTrades || trades[0].ID != 6 || trades[len(trades)-1].ID != int64(n) {
+		t.Errorf("have %d trades from %d, want %d from 6", len(trades), trades[0].ID, orderbook.MaxTrades)
+	}
+}
+
+// Follow an order through its lifecycle: placed, partially filled and
+// canceled.
+func TestBook_CancelOrder_4(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+
+	report, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideSell,
+		OriginalQuantity: decimal.NewFromInt(3),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(100),
+		ID:               "limit",
+		Type:             orderbook.TypeLimit,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+
+	if report.Order.State != orderbook.StatePlaced || !report.Order.LeavesQuantity.Equal(decimal.NewFromInt(3)) {
+		t.Errorf("unexpected order %v", report.Order)
+	}
+
+	if _, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.Zero,
+		ID:               "market",
+		Type:             orderbook.TypeMarket,
+	}); err != nil {
+		t.Error(err)
+	}
+
+	order, err := b.GetOrder("limit")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if order.State != orderbook.StatePartiallyFilled || !order.LeavesQuantity.Equal(decimal.NewFromInt(2)) {
+		t.Errorf("unexpected order %v", order)
+	}
+
+	if err := b.CancelOrder("limit"); err != nil {
+		t.Error(err)
+	}
+
+	order, err = b.GetOrder("limit")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if order.State != orderbook.StateCanceled || order.Reason != orderbook.ReasonCanceled ||
+		!order.LeavesQuantity.IsZero() || !order.ExecutedQuantity.Equal(decimal.NewFromInt(1)) {
+		t.Errorf("unexpected order %v", order)
+	}
+
+	if err := b.CancelOrder("limit"); !errors.Is(err, orderbook.ErrOrderAlreadyCanceled) {
+		t.Errorf("have %v, want ErrOrderAlreadyCanceled", err)
+	}
+
+	assertCountLevels(t, b, 0, 0)
+}
+
+// Invalid orders with a valid ID are recorded as rejected.
+func TestBook_AddOrder_Rejected(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+
+	_, err := b.AddOrder(orderbook.ClientOrder{
+		Side:             orderbook.SideSell,
+		OriginalQuantity: decimal.NewFromInt(-1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.NewFromInt(100),
+		ID:               "negative",
+		Type:             orderbook.TypeLimit,
+	})
+	if !errors.Is(err, orderbook.ErrInvalidQuantity) {
+		t.Errorf("have %v, want ErrInvalidQuantity", err)
+	}
+
+	order, err := b.GetOrder("negative")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if order.State != orderbook.StateRejected || order.Reason != orderbook.ErrInvalidQuantity.Error() {
+		t.Errorf("unexpected order %v", order)
+	}
+
+	if err := b.CancelOrder("negative"); !errors.Is(err, orderbook.ErrCannotCancelOrder) {
+		t.Errorf("have %v, want ErrCannotCancelOrder", err)
+	}
+
+	assertCountLevels(t, b, 0, 0)
+}
+
+// Recorded orders get a handle, which trades refer to and orders may be
+// queried by.  Rejected orders are recorded too, so they get one as well.
+func TestBook_GetOrderByHandle(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+
+	maker, err := b.AddOrder(limitOrder("maker", orderbook.SideSell, 100, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rejected, _ := b.AddOrder(limitOrder("rejected", orderbook.SideSell, -1, 1))
+
+	taker, err := b.AddOrder(limitOrder("taker", orderbook.SideBuy, 100, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if have := []uint64{maker.Order.Handle, rejected.Order.Handle, taker.Order.Handle}; have[0] != 1 ||
+		have[1] != 2 || have[2] != 3 {
+		t.Errorf("have %v, want [1 2 3]", have)
+	}
+
+	if len(taker.Trades) != 1 {
+		t.Fatalf("have %d, want 1", len(taker.Trades))
+	}
+
+	if trade := taker.Trades[0]; trade.MakerHandle != 1 || trade.TakerHandle != 3 || trade.MakerID != "maker" {
+		t.Errorf("unexpected trade %v", trade)
+	}
+
+	for handle, id := range map[uint64]string{1: "maker", 2: "rejected", 3: "taker"} {
+		order, err := b.GetOrderByHandle(handle)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if order.ID != id {
+			t.Errorf("have %s, want %s", order.ID, id)
+		}
+	}
+
+	if _, err := b.GetOrderByHandle(4); !errors.Is(err, orderbook.ErrOrderDoesNotExist) {
+		t.Errorf("have %v, want ErrOrderDoesNotExist", err)
+	}
+}
+
+// Resubmitting a copy of an order from the book creates a fresh order.
+func TestBook_AddOrder_Copy(t *testing.T) {
+	t.Parallel()
+
+	iceberg := limitOrder("iceberg", orderbook.SideSell, 100, 10)
+	iceberg.DisplayQuantity = decimal.NewFromInt(1)
+
+	b := orderbook.NewBook()
+	submit(t, b, iceberg)
+	submit(t, b, limitOrder("buy", orderbook.SideBuy, 100, 5))
+
+	order, err := b.GetOrder("iceberg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order.ID = "copy"
+	order.Price = decimal.NewFromInt(101)
+	order.ExecutedQuantity = decimal.Zero
+	order.DisplayQuantity = decimal.Zero
+
+	report := submit(t, b, order)
+	if !report.Order.ExecutedQuantity.IsZero() || !report.Order.LeavesQuantity.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("have %v executed and %v left, want 0 and 10",
+			report.Order.ExecutedQuantity, report.Order.LeavesQuantity)
+	}
+
+	assertLevels(t, &b.Asks, pq{"100", "1"}, pq{"101", "10"})
+}
+
+// Prices that would alias at the same level get rejected.
+func TestBook_AddOrder_Instrument(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	submit := func(id, price, quantity string) error {
+		_, err := b.AddOrder(orderbook.ClientOrder{
+			Side:             orderbook.SideSell,
+			OriginalQuantity: decimal.RequireFromString(quantity),
+			ExecutedQuantity: decimal.Zero,
+			Price:            decimal.RequireFromString(price),
+			ID:               id,
+			Type:             orderbook.TypeLimit,
+		})
+
+		return err
+	}
+
+	if err := submit("id1", "1.00000001", "1"); err != nil {
+		t.Error(err)
+	}
+
+	if err := submit("id2", "1.000000001", "1"); !errors.Is(err, orderbook.ErrPricePrecision) {
+		t.Errorf("have %v, want ErrPricePrecision", err)
+	}
+
+	if err := submit("id3", "1.000000002", "1"); !errors.Is(err, orderbook.ErrPricePrecision) {
+		t.Errorf("have %v, want ErrPricePrecision", err)
+	}
+
+	assertLevels(t, &b.Asks, pq{"1.00000001", "1"})
+
+	b = orderbook.NewBook(orderbook.WithInstrument(newInstrument()))
+
+	if err := submit("id1", "100.05", "0.2"); err != nil {
+		t.Error(err)
+	}
+
+	if err := submit("id2", "100.02", "0.2"); !errors.Is(err, orderbook.ErrPriceOffTick) {
+		t.Errorf("have %v, want ErrPriceOffTick", err)
+	}
+
+	if err := submit("id3", "10", "0.2"); !errors.Is(err, orderbook.ErrNotionalTooSmall) {
+		t.Errorf("have %v, want ErrNotionalTooSmall", err)
+	}
+
+	order, err := b.GetOrder("id3")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if order.State != orderbook.StateRejected || order.Reason != orderbook.ErrNotionalTooSmall.Error() {
+		t.Errorf("unexpected order %v", order)
+	}
+}
+
+// Iceberg orders show only their display quantity in snapshots.
+func TestBook_AddOrder_Iceberg(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	iceberg := limitOrder("iceberg", orderbook.SideSell, 100, 10)
+	iceberg.DisplayQuantity = decimal.NewFromInt(3)
+
+	submit(t, b, iceberg)
+	submit(t, b, limitOrder("regular", orderbook.SideSell, 100, 1))
+
+	if snapshot := b.GetSnapshot(1); !snapshot.Asks[0].Quantity.Equal(decimal.NewFromInt(4)) {
+		t.Errorf("have %v, want 4", snapshot.Asks[0].Quantity)
+	}
+
+	// A fill-or-kill order sees the hidden quantity as well.
+	fok := limitOrder("fok", orderbook.SideBuy, 100, 11)
+	fok.TimeInForce = orderbook.TimeInForceFOK
+
+	submit(t, b, fok)
+
+	assertCountLevels(t, b, 0, 0)
+
+	b = orderbook.NewBook()
+	submit(t, b, iceberg)
+	submit(t, b, limitOrder("regular", orderbook.SideSell, 100, 1))
+	submit(t, b, limitOrder("buy", orderbook.SideBuy, 100, 4))
+
+	order, err := b.GetOrder("iceberg")
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !order.LeavesQuantity.Equal(decimal.NewFromInt(7)) || !order.VisibleQuantity.Equal(decimal.NewFromInt(3)) {
+		t.Errorf("have leaves %v and visible %v, want 7 and 3", order.LeavesQuantity, order.VisibleQuantity)
+	}
+
+	assertLevels(t, &b.Asks, pq{"100", "3"})
+	assertExecutedQuantities(t, b, iq{"iceberg", "3"}, iq{"regular", "1"})
+
+	// Icebergs must be limit orders.
+	market := orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.Zero,
+		ID:               "market",
+		Type:             orderbook.TypeMarket,
+		DisplayQuantity:  decimal.NewFromInt(1),
+	}
+
+	if _, err := b.AddOrder(market); !errors.Is(err, orderbook.ErrInvalidDisplayQuantity) {
+		t.Errorf("have %v, want ErrInvalidDisplayQuantity", err)
+	}
+}
+
+// Post-only orders never take liquidity: they either get rejected or
+// slide one tick away from the touch.
+func TestBook_AddOrder_PostOnly(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	submit(t, b, limitOrder("sell", orderbook.SideSell, 100, 1))
+
+	reject := limitOrder("reject", orderbook.SideBuy, 100, 1)
+	reject.PostOnly = orderbook.PostOnlyReject
+
+	if _, err := b.AddOrder(reject); !errors.Is(err, orderbook.ErrPostOnlyWouldTake) {
+		t.Errorf("have %v, want ErrPostOnlyWouldTake", err)
+	}
+
+	// Post-only orders that do not cross rest as usual.
+	maker := limitOrder("maker", orderbook.SideBuy, 99, 1)
+	maker.PostOnly = orderbook.PostOnlyReject
+	submit(t, b, maker)
+
+	slide := limitOrder("slide", orderbook.SideBuy, 101, 2)
+	slide.PostOnly = orderbook.PostOnlySlide
+
+	report := submit(t, b, slide)
+	if want := decimal.RequireFromString("99.99999999"); !report.Order.Price.Equal(want) {
+		t.Errorf("have %v, want %v", report.Order.Price, want)
+	}
+
+	assertStates(t, b, map[string]int{
+		"sell":   orderbook.StatePlaced,
+		"reject": orderbook.StateRejected,
+		"maker":  orderbook.StatePlaced,
+		"slide":  orderbook.StatePlaced,
+	})
+	assertLevels(t, &b.Asks, pq{"100", "1"})
+	assertLevels(t, &b.Bids, pq{"99.99999999", "2"}, pq{"99", "1"})
+
+	// Market orders always take liquidity.
+	market := orderbook.ClientOrder{
+		Side:             orderbook.SideBuy,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.Zero,
+		ID:               "market",
+		Type:             orderbook.TypeMarket,
+		PostOnly:         orderbook.PostOnlyReject,
+	}
+
+	if _, err := b.AddOrder(market); !errors.Is(err, orderbook.ErrInvalidPostOnly) {
+		t.Errorf("have %v, want ErrInvalidPostOnly", err)
+	}
+}
+
+func newSelfTradeBook(t *testing.T) *orderbook.Book {
+	t.Helper()
+
+	b := orderbook.NewBook()
+
+	own := limitOrder("own", orderbook.SideSell, 100, 2)
+	own.Owner = "alice"
+
+	other := limitOrder("other", orderbook.SideSell, 100, 2)
+	other.Owner = "bob"
+
+	submit(t, b, own)
+	submit(t, b, other)
+
+	return b
+}
+
+// Orders of the same owner never trade with each other.
+func TestBook_AddOrder_SelfTrade(t *testing.T) {
+	t.Parallel()
+
+	taker := limitOrder("taker", orderbook.SideBuy, 100, 3)
+	taker.Owner = "alice"
+
+	// Cancel newest: the taker gets canceled before trading at all.
+	b := newSelfTradeBook(t)
+	report := submit(t, b, taker)
+
+	if len(report.Trades) != 0 || len(report.Prevented) != 1 || report.Prevented[0].MakerID != "own" {
+		t.Errorf("unexpected report %v", report)
+	}
+
+	if report.Order.State != orderbook.StateCanceled || report.Order.Reason != orderbook.ReasonSelfTrade {
+		t.Errorf("unexpected order %v", report.Order)
+	}
+
+	assertLevels(t, &b.Asks, pq{"100", "4"})
+
+	// Cancel oldest: the maker gets canceled and the taker keeps matching.
+	b = newSelfTradeBook(t)
+	taker.SelfTrade = orderbook.SelfTradeCancelOldest
+	submit(t, b, taker)
+
+	assertStates(t, b, map[string]int{
+		"own":   orderbook.StateCanceled,
+		"other": orderbook.StateFilled,
+		"taker": orderbook.StatePartiallyFilled,
+	})
+	assertCountLevels(t, b, 0, 1)
+
+	// Cancel both.
+	b = newSelfTradeBook(t)
+	taker.SelfTrade = orderbook.SelfTradeCancelBoth
+	submit(t, b, taker)
+
+	assertStates(t, b, map[string]int{
+		"own":   orderbook.StateCanceled,
+		"other": orderbook.StatePlaced,
+		"taker": orderbook.StateCanceled,
+	})
+	assertLevels(t, &b.Asks, pq{"100", "2"})
+
+	// Decrement and cancel: the smaller order gets canceled and the larger
+	// one decremented by its quantity.
+	b = newSelfTradeBook(t)
+	taker.SelfTrade = orderbook.SelfTradeDecrement
+	report = submit(t, b, taker)
+
+	if !report.Order.PreventedQuantity.Equal(decimal.NewFromInt(2)) {
+		t.Errorf("have %v, want 2", report.Order.PreventedQuantity)
+	}
+
+	assertStates(t, b, map[string]int{
+		"own":   orderbook.StateCanceled,
+		"other": orderbook.StatePartiallyFilled,
+		"taker": orderbook.StateCanceled,
+	})
+	assertExecutedQuantities(t, b, iq{"own", "0"}, iq{"other", "1"}, iq{"taker", "1"})
+	assertLevels(t, &b.Asks, pq{"100", "1"})
+
+	// Self-trade prevention can be turned off.
+	b = newSelfTradeBook(t)
+	taker.SelfTrade = orderbook.SelfTradeAllow
+	submit(t, b, taker)
+
+	assertExecutedQuantities(t, b, iq{"own", "2"}, iq{"other", "1"}, iq{"taker", "3"})
+}
+
+// Fill-or-kill orders do not count on the quantity of their own owner.
+func TestBook_AddOrder_SelfTradeFOK(t *testing.T) {
+	t.Parallel()
+
+	taker := limitOrder("taker", orderbook.SideBuy, 100, 3)
+	taker.Owner = "alice"
+	taker.TimeInForce = orderbook.TimeInForceFOK
+	taker.SelfTrade = orderbook.SelfTradeCancelOldest
+
+	b := newSelfTradeBook(t)
+	if _, err := b.AddOrder(taker); !errors.Is(err, orderbook.ErrFillOrKillNotFilled) {
+		t.Errorf("have %v, want ErrFillOrKillNotFilled", err)
+	}
+
+	assertLevels(t, &b.Asks, pq{"100", "4"})
+
+	taker.ID = "fok"
+	taker.OriginalQuantity = decimal.NewFromInt(2)
+	submit(t, b, taker)
+
+	assertStates(t, b, map[string]int{
+		"own":   orderbook.StateCanceled,
+		"other": orderbook.StateFilled,
+		"fok":   orderbook.StateFilled,
+	})
+}
+
+// A replenished iceberg goes to the back of the queue, so the maker of the
+// taker's own behind it gets reached before the iceberg's hidden quantity.
+func TestBook_AddOrder_SelfTradeFOKIceberg(t *testing.T) {
+	t.Parallel()
+
+	iceberg := limitOrder("iceberg", orderbook.SideSell, 100, 3)
+	iceberg.DisplayQuantity = decimal.NewFromInt(1)
+	iceberg.Owner = "bob"
+
+	own := limitOrder("own", orderbook.SideSell, 100, 1)
+	own.Owner = "alice"
+
+	b := orderbook.NewBook()
+	submit(t, b, iceberg)
+	submit(t, b, own)
+
+	taker := limitOrder("fok", orderbook.SideBuy, 100, 3)
+	taker.Owner = "alice"
+	taker.TimeInForce = orderbook.TimeInForceFOK
+	taker.SelfTrade = orderbook.SelfTradeCancelNewest
+
+	if _, err := b.AddOrder(taker); !errors.Is(err, orderbook.ErrFillOrKillNotFilled) {
+		t.Errorf("have %v, want ErrFillOrKillNotFilled", err)
+	}
+
+	assertExecutedQuantities(t, b, iq{"iceberg", "0"}, iq{"own", "0"})
+	assertLevels(t, &b.Asks, pq{"100", "2"})
+}
+
+// Decreasing the quantity keeps the queue position, anything else loses
+// it.
+func TestBook_AmendOrder(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	submit(t, b, limitOrder("a", orderbook.SideSell, 100, 3))
+	submit(t, b, limitOrder("b", orderbook.SideSell, 100, 3))
+
+	report, err := b.AmendOrder("a", decimal.Zero, decimal.NewFromInt(2))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !report.Order.LeavesQuantity.Equal(decimal.NewFromInt(2)) {
+		t.Errorf("have %v, want 2", report.Order.LeavesQuantity)
+	}
+
+	submit(t, b, limitOrder("buy1", orderbook.SideBuy, 100, 1))
+	assertExecutedQuantities(t, b, iq{"a", "1"}, iq{"b", "0"})
+
+	// Increasing the quantity sends the order to the back of the queue.
+	if _, err := b.AmendOrder("a", decimal.Zero, decimal.NewFromInt(4)); err != nil {
+		t.Error(err)
+	}
+
+	submit(t, b, limitOrder("buy2", orderbook.SideBuy, 100, 1))
+	assertExecutedQuantities(t, b, iq{"a", "1"}, iq{"b", "1"})
+	assertLevels(t, &b.Asks, pq{"100", "5"})
+
+	// Changing the price may match the order right away.
+	submit(t, b, limitOrder("bid", orderbook.SideBuy, 99, 2))
+
+	report, err = b.AmendOrder("a", decimal.NewFromInt(99), decimal.Zero)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(report.Trades) != 1 || report.Order.State != orderbook.StatePartiallyFilled {
+		t.Errorf("unexpected report %v", report)
+	}
+
+	assertExecutedQuantities(t, b, iq{"a", "3"}, iq{"bid", "2"})
+	assertLevels(t, &b.Asks, pq{"99", "1"}, pq{"100", "2"})
+
+	// The new quantity must leave something to execute.
+	_, err = b.AmendOrder("a", decimal.Zero, decimal.NewFromInt(3))
+	if !errors.Is(err, orderbook.ErrInvalidQuantity) {
+		t.Errorf("have %v, want ErrInvalidQuantity", err)
+	}
+
+	_, err = b.AmendOrder("bid", decimal.Zero, decimal.NewFromInt(5))
+	if !errors.Is(err, orderbook.ErrOrderAlreadyFilled) {
+		t.Errorf("have %v, want ErrOrderAlreadyFilled", err)
+	}
+
+	_, err = b.AmendOrder("missing", decimal.Zero, decimal.NewFromInt(5))
+	if !errors.Is(err, orderbook.ErrOrderDoesNotExist) {
+		t.Errorf("have %v, want ErrOrderDoesNotExist", err)
+	}
+}
+
+func newMarketBook(t *testing.T) *orderbook.Book {
+	t.Helper()
+
+	b := orderbook.NewBook()
+	submit(t, b, limitOrder("sell100", orderbook.SideSell, 100, 1))
+	submit(t, b, limitOrder("sell101", orderbook.SideSell, 101, 1))
+	submit(t, b, limitOrder("sell110", orderbook.SideSell, 110, 5))
+
+	return b
+}
+
+func marketOrder(id string, side int, quantity int64) orderbook.ClientOrder {
+	return orderbook.ClientOrder{
+		Side:             side,
+		OriginalQuantity: decimal.NewFromInt(quantity),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.Zero,
+		ID:               id,
+		Type:             orderbook.TypeMarket,
+	}
+}
+
+// Market orders do not trade further than their max slippage from the
+// touch.
+func TestBook_AddOrder_Slippage(t *testing.T) {
+	t.Parallel()
+
+	b := newMarketBook(t)
+
+	order := marketOrder("market", orderbook.SideBuy, 3)
+	order.MaxSlippage = decimal.RequireFromString("0.02")
+
+	report, err := b.AddOrder(order)
+	if !errors.Is(err, orderbook.ErrMarketOrderNotFullyExecuted) {
+		t.Errorf("have %v, want ErrMarketOrderNotFullyExecuted", err)
+	}
+
+	if report.Order.State != orderbook.StateCanceled || report.Order.Reason != orderbook.ReasonSlippage {
+		t.Errorf("unexpected order %v", report.Order)
+	}
+
+	if executed := report.Order.ExecutedQuantity; !executed.Equal(decimal.NewFromInt(2)) ||
+		!report.QuoteQuantity.Equal(decimal.NewFromInt(201)) {
+		t.Errorf("have %v for %v, want 2 for 201", executed, report.QuoteQuantity)
+	}
+
+	assertLevels(t, &b.Asks, pq{"110", "5"})
+
+	// Fill-or-kill market orders do not execute partially.
+	b = newMarketBook(t)
+	order.TimeInForce = orderbook.TimeInForceFOK
+
+	if _, err := b.AddOrder(order); !errors.Is(err, orderbook.ErrFillOrKillNotFilled) {
+		t.Errorf("have %v, want ErrFillOrKillNotFilled", err)
+	}
+
+	assertCountLevels(t, b, 3, 0)
+}
+
+// Market orders may be sized in quote currency.
+func TestBook_AddOrder_QuoteQuantity(t *testing.T) {
+	t.Parallel()
+
+	b := newMarketBook(t)
+
+	order := marketOrder("quote", orderbook.SideBuy, 0)
+	order.QuoteQuantity = decimal.NewFromInt(311)
+
+	report := submit(t, b, order)
+	if executed := report.Order.ExecutedQuantity; !executed.Equal(decimal.NewFromInt(3)) ||
+		!report.QuoteQuantity.Equal(decimal.NewFromInt(311)) {
+		t.Errorf("have %v for %v, want 3 for 311", executed, report.QuoteQuantity)
+	}
+
+	assertLevels(t, &b.Asks, pq{"110", "4"})
+
+	// There is not enough liquidity to spend all of it.
+	order.ID = "large"
+	order.QuoteQuantity = decimal.NewFromInt(1000)
+
+	report, err := b.AddOrder(order)
+	if !errors.Is(err, orderbook.ErrMarketOrderNotFullyExecuted) {
+		t.Errorf("have %v, want ErrMarketOrderNotFullyExecuted", err)
+	}
+
+	if report.Order.State != orderbook.StateCanceled || !report.Order.ExecutedQuantity.Equal(decimal.NewFromInt(4)) {
+		t.Errorf("unexpected order %v", report.Order)
+	}
+
+	// Quote quantity is for market orders only and replaces the quantity.
+	limit := limitOrder("limit", orderbook.SideBuy, 100, 1)
+	limit.QuoteQuantity = decimal.NewFromInt(100)
+
+	if _, err := b.AddOrder(limit); !errors.Is(err, orderbook.ErrInvalidQuoteQuantity) {
+		t.Errorf("have %v, want ErrInvalidQuoteQuantity", err)
+	}
+}
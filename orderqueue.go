@@ -3,153 +3,224 @@ package orderbook
 import (
 	"fmt"
 	"strings"
-
-	"github.com/shopspring/decimal"
 )
 
-// BinarySearch returns index of the search key, if it is contained in
-// the array, otherwise (-(insertion point) – 1).
-func BinarySearch(xs []*Order, x int) int {
-	low, high := 0, len(xs)
-
-	for low < high {
-		mid := low + (high-low)/2
+// orderIndex maps handles to the orders resting in all queues sharing
+// it, see Book.  It also keeps the orders removed from those queues, so
+// their memory gets reused by the next ones added.
+type orderIndex struct {
+	orders map[uint64]*Order
+	free   *Order // Removed orders, linked through next.
+}
 
-		if xs[mid].InsertionIndex < x {
-			low = mid + 1
-		} else {
-			high = mid
-		}
+func newOrderIndex(n int) *orderIndex {
+	return &orderIndex{
+		orders: make(map[uint64]*Order, n),
+		free:   nil,
 	}
+}
 
-	if low < len(xs) && xs[low].InsertionIndex == x {
-		return low
+// alloc returns a copy of the given order, reusing a removed one if
+// there is any.
+func (x *orderIndex) alloc(order Order) *Order {
+	node := x.free
+	if node == nil {
+		node = new(Order)
+	} else {
+		x.free = node.next
 	}
 
-	return -low - 1
+	*node = order
+
+	return node
+}
+
+// release keeps the given removed order for reuse.
+func (x *orderIndex) release(node *Order) {
+	*node = Order{} //nolint:exhaustruct
+	node.next = x.free
+	x.free = node
 }
 
 // OrderQueue holds all the orders at a particular level of the order book.  It keeps them
 // in a queue (FIFO), so orders of the same price level get executed in the order they
-// were submitted.  OrderQueue also allows querying using the order ID.
+// were submitted.  OrderQueue also allows querying using the order handle.
+//
+// The queue is an intrusive doubly linked list: orders link to their neighbours
+// themselves and the handle index gets shared by all levels of a book, so adding,
+// removing and popping orders all take O(1).  An OrderQueue must not be copied once
+// orders have been added to it.
 type OrderQueue struct {
-	queue []*Order
+	head *Order
+	tail *Order
+	len  int
 
-	// indices maps an order ID to its insertion order index.
-	// This way removing by ID may use binarySearch() and thus
-	// take O(logN + copy).
-	indices map[string]int
+	index *orderIndex
 
 	next int
 }
 
+// NewOrderQueue creates a queue with its own handle index, sized for n
+// orders.
 func NewOrderQueue(n int) OrderQueue {
+	return newOrderQueue(newOrderIndex(n))
+}
+
+func newOrderQueue(index *orderIndex) OrderQueue {
 	return OrderQueue{
-		queue:   make([]*Order, 0, n),
-		indices: make(map[string]int),
-		next:    0,
+		head:  nil,
+		tail:  nil,
+		len:   0,
+		index: index,
+		next:  0,
 	}
 }
 
 func (q *OrderQueue) Add(order Order) bool {
-	if _, ok := q.indices[order.ID]; ok {
-		// There is already an order with this ID.
+	if _, ok := q.index.orders[order.Handle]; ok {
+		// There is already an order with this handle.
 		return false
 	}
 
-	// Set insertionIndex to order and also save it to our
-	// ID -> insertionIndex mapping.
+	// InsertionIndex tells clients the order of submission, see
+	// ClientRestingOrder.
 	order.InsertionIndex = q.next
-	q.indices[order.ID] = q.next
 	q.next++
 
+	node := q.index.alloc(order)
+	q.index.orders[order.Handle] = node
+
 	// Append order to queue.
-	q.queue = append(q.queue, &order)
+	node.prev, node.next, node.queue = q.tail, nil, q
+	if q.tail == nil {
+		q.head = node
+	} else {
+		q.tail.next = node
+	}
+
+	q.tail = node
+	q.len++
 
 	return true
 }
 
-func (q *OrderQueue) Remove() *Order {
-	// Take order.
-	order := q.queue[0]
-
-	// Pop queue.
-	q.queue = q.queue[1:]
+// Peek returns the order at the front of the queue without removing it,
+// or nil if the queue is empty.
+func (q *OrderQueue) Peek() *Order {
+	return q.head
+}
 
-	// Delete from the ID -> index mapping.
-	delete(q.indices, order.ID)
+// Remove pops the order at the front of the queue.
+func (q *OrderQueue) Remove() Order {
+	order := q.head.detached()
+	q.unlink(q.head)
 
 	return order
 }
 
-func (q *OrderQueue) RemoveByID(orderID string) bool {
-	// Check if we have an order with this ID.
-	insertionIndex, ok := q.indices[orderID]
+func (q *OrderQueue) RemoveByID(handle uint64) bool {
+	// Check if we have an order with this handle.
+	node := q.find(handle)
+	if node == nil {
+		return false
+	}
 
-	if ok {
-		// If yes, locate its index in the queue.
-		i := BinarySearch(q.queue, insertionIndex)
-		if i >= 0 {
-			order := q.queue[i]
+	q.unlink(node)
 
-			// Delete order from queue.
-			copy(q.queue[i:], q.queue[i+1:])
-			q.queue = q.queue[:len(q.queue)-1]
+	return true
+}
 
-			// Delete order from map.
-			delete(q.indices, order.ID)
+// unlink takes the given order out of the queue and the index.
+func (q *OrderQueue) unlink(node *Order) {
+	if node.prev == nil {
+		q.head = node.next
+	} else {
+		node.prev.next = node.next
+	}
 
-			return true
-		}
+	if node.next == nil {
+		q.tail = node.prev
+	} else {
+		node.next.prev = node.prev
 	}
 
-	return false
+	q.len--
+
+	delete(q.index.orders, node.Handle)
+	q.index.release(node)
 }
 
-func (q *OrderQueue) GetByID(orderID string) (Order, bool) {
-	insertionIndex, ok := q.indices[orderID]
+// find returns the order with the given handle, so it can be modified
+// in place, or nil.
+func (q *OrderQueue) find(handle uint64) *Order {
+	// The index is shared, so the order may be in another queue.
+	if node, ok := q.index.orders[handle]; ok && node.queue == q {
+		return node
+	}
 
-	if ok {
-		i := BinarySearch(q.queue, insertionIndex)
+	return nil
+}
 
-		if i >= 0 {
-			order := q.queue[i]
+// Position returns the index of the order with the given handle in the
+// queue, or -1.  It takes O(N).
+func (q *OrderQueue) Position(handle uint64) int {
+	node := q.find(handle)
+	if node == nil {
+		return -1
+	}
 
-			return *order, true
-		}
+	i := 0
+	for x := q.head; x != node; x = x.next {
+		i++
+	}
+
+	return i
+}
+
+func (q *OrderQueue) GetByID(handle uint64) (Order, bool) {
+	if order := q.find(handle); order != nil {
+		return order.detached(), true
 	}
 
 	return Order{
-		ID:             orderID,
-		Quantity:       decimal.Zero,
-		InsertionIndex: insertionIndex,
+		Handle:         handle,
+		Quantity:       0,
+		InsertionIndex: 0,
+		Hidden:         0,
+		Display:        0,
+		Owner:          "",
+		SelfTrade:      SelfTradeCancelNewest,
+		prev:           nil,
+		next:           nil,
+		queue:          nil,
 	}, false
 }
 
+// Iter returns the orders from the front of the queue to its back.  It
+// allocates, hot paths should walk the queue with Peek and Order.Next.
 func (q *OrderQueue) Iter() []*Order {
-	return q.queue
-}
+	ans := make([]*Order, 0, q.len)
 
-func (q *OrderQueue) Len() int {
-	// Len() also makes sure both of our data structures have the
-	// same length.
-	if len(q.queue) != len(q.indices) {
-		msg := fmt.Sprintf("invariant: len(queue)=%d len(indices)=%d\n", len(q.queue), len(q.indices))
-		panic(msg)
+	for x := q.head; x != nil; x = x.next {
+		ans = append(ans, x)
 	}
 
-	return len(q.queue)
+	return ans
+}
+
+func (q *OrderQueue) Len() int {
+	return q.len
 }
 
 func (q *OrderQueue) String() string {
 	var b strings.Builder
 
-	for i, order := range q.queue {
-		if i > 0 {
+	for x := q.head; x != nil; x = x.next {
+		if x != q.head {
 			fmt.Fprintf(&b, "\n")
 		}
 
-		fmt.Fprintf(&b, "    %v", order)
+		fmt.Fprintf(&b, "    %v", x)
 	}
 
 	return b.String()
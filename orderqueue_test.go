@@ -1,44 +1,13 @@
 package orderbook_test
 
 import (
-	"strconv"
+	"fmt"
+	"math/rand"
 	"testing"
 
-	"github.com/shopspring/decimal"
 	"github.com/ydm/orderbook"
 )
 
-func TestBinarySearch(t *testing.T) {
-	t.Parallel()
-
-	assertEq := func(have, want int) {
-		t.Helper()
-
-		if have != want {
-			t.Errorf("have %d, want %d", have, want)
-		}
-	}
-
-	xs := []int{1, 3, 6, 10, 15, 21, 28, 36, 45, 55}
-	ys := make([]*orderbook.Order, len(xs))
-
-	for i, x := range xs {
-		order := orderbook.NewOrder(strconv.Itoa(i), decimal.NewFromInt(1))
-		ys[i] = &order
-		ys[i].InsertionIndex = x
-	}
-
-	assertEq(orderbook.BinarySearch(ys, 1), 0)
-	assertEq(orderbook.BinarySearch(ys, 3), 1)
-	assertEq(orderbook.BinarySearch(ys, 10), 3)
-	assertEq(orderbook.BinarySearch(ys, 45), 8)
-	assertEq(orderbook.BinarySearch(ys, 55), 9)
-	assertEq(orderbook.BinarySearch(ys, -1), -1)
-	assertEq(orderbook.BinarySearch(ys, 2), -2)
-	assertEq(orderbook.BinarySearch(ys, 50), -10)
-	assertEq(orderbook.BinarySearch(ys, 60), -11)
-}
-
 func TestOrderQueue(t *testing.T) {
 	t.Parallel()
 
@@ -48,8 +17,8 @@ func TestOrderQueue(t *testing.T) {
 	}
 
 	inp := orderbook.Order{
-		ID:             "7bfa0e20",
-		Quantity:       decimal.NewFromInt(1),
+		Handle:         7,
+		Quantity:       1,
 		InsertionIndex: 0,
 	}
 
@@ -65,11 +34,11 @@ func TestOrderQueue(t *testing.T) {
 		t.Errorf("have %d, want 0", q.Len())
 	}
 
-	if inp.ID != out.ID || !inp.Quantity.Equal(out.Quantity) {
+	if inp.Handle != out.Handle || inp.Quantity != out.Quantity {
 		t.Errorf("have %v, want %v", out, inp)
 	}
 
-	// Make sure an order with the same ID cannot be submitted more than once.
+	// Make sure an order with the same handle cannot be submitted more than once.
 	for i := 1; i <= 16; i++ {
 		q.Add(inp)
 
@@ -87,10 +56,9 @@ func TestOrderQueue_Remove(t *testing.T) {
 	q := orderbook.NewOrderQueue(8)
 
 	for i := 0; i < N; i++ {
-		s := strconv.Itoa(i)
 		o := orderbook.Order{
-			ID:             s,
-			Quantity:       decimal.NewFromInt(int64(i)),
+			Handle:         uint64(i),
+			Quantity:       int64(i),
 			InsertionIndex: 0,
 		}
 		q.Add(o)
@@ -102,11 +70,11 @@ func TestOrderQueue_Remove(t *testing.T) {
 		}
 
 		popped := q.Remove()
-		wantedID := strconv.Itoa(i)
-		wantedQuantity := decimal.NewFromInt(int64(i))
+		wantedHandle := uint64(i)
+		wantedQuantity := int64(i)
 
-		if popped.ID != wantedID || !popped.Quantity.Equal(wantedQuantity) {
-			t.Errorf("have={%s %v}, want={%s, %s}", popped.ID, popped.Quantity, wantedID, wantedID)
+		if popped.Handle != wantedHandle || popped.Quantity != wantedQuantity {
+			t.Errorf("have={%d %d}, want={%d, %d}", popped.Handle, popped.Quantity, wantedHandle, wantedQuantity)
 		}
 
 		if q.Len() != (N - i - 1) {
@@ -121,10 +89,9 @@ func TestOrderQueue_RemoveByID(t *testing.T) {
 	q := orderbook.NewOrderQueue(2)
 
 	for i := 0; i < 1000; i++ {
-		s := strconv.Itoa(i)
 		o := orderbook.Order{
-			ID:             s,
-			Quantity:       decimal.NewFromInt(int64(i)),
+			Handle:         uint64(i),
+			Quantity:       int64(i),
 			InsertionIndex: 0,
 		}
 		q.Add(o)
@@ -134,15 +101,15 @@ func TestOrderQueue_RemoveByID(t *testing.T) {
 		t.Fail()
 	}
 
-	if q.RemoveByID("nonexistent") {
+	if q.RemoveByID(1000) {
 		t.Fail()
 	}
 
-	if !q.RemoveByID("681") {
+	if !q.RemoveByID(681) {
 		t.Fail()
 	}
 
-	if q.RemoveByID("681") {
+	if q.RemoveByID(681) {
 		t.Fail()
 	}
 
@@ -152,8 +119,146 @@ func TestOrderQueue_RemoveByID(t *testing.T) {
 
 	for q.Len() > 0 {
 		order := q.Remove()
-		if order.ID == "681" {
+		if order.Handle == 681 {
 			t.Error()
 		}
 	}
 }
+
+// Orders removed from the middle keep the rest in place and new ones go
+// to the back.
+func TestOrderQueue_Iter(t *testing.T) {
+	t.Parallel()
+
+	q := orderbook.NewOrderQueue(4)
+
+	for i := 1; i <= 5; i++ {
+		q.Add(orderbook.NewOrder(uint64(i), int64(i)))
+	}
+
+	q.RemoveByID(2)
+	q.RemoveByID(4)
+	q.Remove()
+	q.Add(orderbook.NewOrder(6, 6))
+
+	have := make([]uint64, 0)
+	for _, order := range q.Iter() {
+		have = append(have, order.Handle)
+	}
+
+	if fmt.Sprint(have) != "[3 5 6]" {
+		t.Errorf("have %v, want [3 5 6]", have)
+	}
+
+	if position := q.Position(6); position != 2 {
+		t.Errorf("have %d, want 2", position)
+	}
+
+	if order, ok := q.GetByID(5); !ok || order.Quantity != 5 || order.Next() != nil {
+		t.Errorf("have %v, want order 5", order)
+	}
+
+	if _, ok := q.GetByID(4); ok {
+		t.Error("removed order found")
+	}
+}
+
+var benchmarkDepths = []int{100, 10000}
+
+// Each iteration cancels a random order of a deep level and adds a new
+// one at the back, so the depth stays the same.
+func BenchmarkOrderQueue_RemoveByID(b *testing.B) {
+	for _, depth := range benchmarkDepths {
+		b.Run(fmt.Sprint(depth), func(b *testing.B) {
+			q := orderbook.NewOrderQueue(depth)
+			random := rand.New(rand.NewSource(1)) //nolint:gosec
+			handles := make([]uint64, depth)
+
+			for i := range handles {
+				handles[i] = uint64(i)
+				q.Add(orderbook.NewOrder(handles[i], 1))
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				j := random.Intn(depth)
+				if !q.RemoveByID(handles[j]) {
+					b.Fatal("not removed")
+				}
+
+				handles[j] = uint64(depth + i)
+				q.Add(orderbook.NewOrder(handles[j], 1))
+			}
+		})
+	}
+}
+
+// Each iteration pops the front of a deep level and adds a new order at
+// the back, the way a busy level gets filled.
+func BenchmarkOrderQueue_Remove(b *testing.B) {
+	for _, depth := range benchmarkDepths {
+		b.Run(fmt.Sprint(depth), func(b *testing.B) {
+			q := orderbook.NewOrderQueue(depth)
+
+			for i := 0; i < depth; i++ {
+				q.Add(orderbook.NewOrder(uint64(i), 1))
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				q.Remove()
+				q.Add(orderbook.NewOrder(uint64(depth+i), 1))
+			}
+		})
+	}
+}
+
+// Cancels and fills interleave on a single deep level of a ladder.
+// Every order that gets removed is replaced by a new one at the back.
+func BenchmarkLadder_CancelDeepLevel(b *testing.B) {
+	const price = 100
+
+	for _, depth := range benchmarkDepths {
+		b.Run(fmt.Sprint(depth), func(b *testing.B) {
+			ladder := orderbook.NewLadder(orderbook.Ask)
+			random := rand.New(rand.NewSource(1)) //nolint:gosec
+			handles := make([]uint64, depth)
+			slots := make(map[uint64]int, depth)
+			next := uint64(1)
+
+			replace := func(slot int) {
+				handles[slot] = next
+				slots[next] = slot
+				ladder.AddOrder(price, orderbook.NewOrder(next, 1))
+				next++
+			}
+
+			for i := range handles {
+				replace(i)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				// Nine cancels for every fill.
+				handle := handles[random.Intn(depth)]
+
+				if i%10 == 9 {
+					handle = ladder.Best().Orders.Peek().Handle
+					ladder.MatchOrderMarket(orderbook.NewOrder(0, 1))
+				} else if !ladder.RemoveOrder(price, handle) {
+					b.Fatal("not removed")
+				}
+
+				slot := slots[handle]
+				delete(slots, handle)
+				replace(slot)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package orderbook
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// QueuePosition tells where a resting order stands in the queue of its
+// price level.  Quantities are visible ones, hidden quantities of iceberg
+// orders are not counted.
+type QueuePosition struct {
+	Price decimal.Decimal `json:"price"`
+	Index int             `json:"index"` // Zero for the order executing next.
+	Ahead decimal.Decimal `json:"ahead"` // Quantity of the orders in front.
+	Total decimal.Decimal `json:"total"` // Quantity of the whole level.
+}
+
+// QueuePosition returns the position of the given resting order in the
+// queue of its price level.
+func (b *Book) QueuePosition(id string) (QueuePosition, error) {
+	if id == "" {
+		return QueuePosition{}, ErrInvalidID
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	order, ok := b.lookup(id)
+	if !ok {
+		return QueuePosition{}, ErrOrderDoesNotExist
+	}
+
+	if order.State != StatePlaced && order.State != StatePartiallyFilled {
+		return QueuePosition{}, ErrOrderNotResting
+	}
+
+	ladder, _, err := b.matchSides(order.Side)
+	if err != nil {
+		return QueuePosition{}, err
+	}
+
+	level, ok := ladder.GetLevel(order.units.price)
+	if !ok {
+		panic("illegal state")
+	}
+
+	index := level.Orders.Position(order.Handle)
+	if index < 0 {
+		panic("illegal state")
+	}
+
+	var ahead int64
+	for _, x := range level.Orders.Iter()[:index] {
+		ahead += x.Quantity
+	}
+
+	return QueuePosition{
+		Price: b.Instrument.Price(level.Price),
+		Index: index,
+		Ahead: b.Instrument.Quantity(ahead),
+		Total: b.Instrument.Quantity(level.TotalQuantity()),
+	}, nil
+}
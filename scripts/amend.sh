@@ -0,0 +1,24 @@
+#!/bin/bash
+
+# This script amends the price and/or quantity of an order.  Zero keeps
+# the current value.
+
+if [ -z "$3" ] ; then
+    echo "usage: $0 <id> <price> <quantity>"
+    exit 1
+fi
+
+SERVER=127.0.0.1:7701
+
+read -d '' BODY << EOF
+{
+    "price": "$2",
+    "quantity": "$3"
+}
+EOF
+
+curl -X PATCH \
+     -H "Content-Type: application/json" \
+     -d "$BODY" \
+     $SERVER/orders/$1
+echo
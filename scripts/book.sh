@@ -1,4 +1,6 @@
 #!/bin/bash
 
+# This script prints the order book of the given (or default) symbol.
+
 SERVER=127.0.0.1:7701
-curl $SERVER/book/?depth=5
+curl "$SERVER/book/?depth=5&symbol=$1"
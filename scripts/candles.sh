@@ -0,0 +1,18 @@
+#!/bin/bash
+
+# This script prints the candles of the given interval (e.g. 1s, 1m, 5m
+# or 1h) of the given (or default) symbol.  The RFC 3339 from and to
+# times are optional.
+
+if [ -z "$1" ] ; then
+    echo "usage: $0 <interval> [from] [to] [symbol]"
+    exit 1
+fi
+
+SERVER=127.0.0.1:7701
+curl -G "$SERVER/candles" \
+     --data-urlencode "interval=$1" \
+     --data-urlencode "from=$2" \
+     --data-urlencode "to=$3" \
+     --data-urlencode "symbol=$4"
+echo
@@ -0,0 +1,13 @@
+#!/bin/bash
+
+# This script queries an order by the numeric handle the exchange
+# assigned to it.
+
+if [ -z "$1" ] ; then
+    echo "usage: $0 <handle>"
+    exit 1
+fi
+
+SERVER=127.0.0.1:7701
+curl $SERVER/handles/$1
+echo
@@ -0,0 +1,8 @@
+#!/bin/bash
+
+# This script prints the resting orders of the given (or default) symbol,
+# level by level.
+
+SERVER=127.0.0.1:7701
+curl "$SERVER/book/l3?depth=5&symbol=$1"
+echo
@@ -0,0 +1,13 @@
+#!/bin/bash
+
+# This script prints the queue position of a resting order: its index in
+# the queue of its price level and the quantity ahead of it.
+
+if [ -z "$1" ] ; then
+    echo "usage: $0 <id>"
+    exit 1
+fi
+
+SERVER=127.0.0.1:7701
+curl $SERVER/orders/$1/position
+echo
@@ -0,0 +1,8 @@
+#!/bin/bash
+
+# This script prints the rolling 24h statistics of the given (or default)
+# symbol.
+
+SERVER=127.0.0.1:7701
+curl "$SERVER/stats?symbol=$1"
+echo
@@ -0,0 +1,37 @@
+#!/usr/bin/env python
+
+import argparse
+import json
+
+import websocket
+
+
+def parse():
+    parser = argparse.ArgumentParser()
+    parser.add_argument('-s', '--symbol', action='append', default=[],
+                        help='subscribe to this symbol, may be repeated')
+    parser.add_argument('-d', '--depth', type=int, default=10,
+                        help='levels per side, 0 for all of them')
+    return parser.parse_args()
+
+
+def main():
+    args = parse()
+    ws = websocket.create_connection('ws://127.0.0.1:7701/stream')
+    for symbol in args.symbol or ['']:
+        ws.send(json.dumps({'method': 'subscribe', 'symbol': symbol, 'depth': args.depth}))
+
+    # Each message of a symbol carries the sequence of the previous one.
+    last = {}
+    while True:
+        message = json.loads(ws.recv())
+        symbol = message['symbol']
+        if message['type'] not in ('snapshot', 'error') and last.get(symbol) != message['previous']:
+            print('Gap detected, resubscribing to', symbol)
+            ws.send(json.dumps({'method': 'subscribe', 'symbol': symbol, 'depth': args.depth}))
+        last[symbol] = message['sequence']
+        print(message)
+
+
+if __name__ == '__main__':
+    main()
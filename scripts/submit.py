@@ -7,27 +7,55 @@ import requests
 
 
 def parse():
-    types = ['limit', 'market']
+    types = ['limit', 'market', 'stop', 'stop-limit']
     sides = ['buy', 'sell']
+    tifs = ['gtc', 'ioc', 'fok', 'gtd', 'day']
+    postOnlys = ['none', 'reject', 'slide']
+    stps = ['cancel-newest', 'cancel-oldest', 'cancel-both', 'decrement', 'allow']
 
     parser = argparse.ArgumentParser()
 
-    # parser.add_argument('-s', '--symbol')
+    parser.add_argument('-s', '--symbol', default='')
     parser.add_argument('-d', '--side', default='buy', choices=sides)
     parser.add_argument('-i', '--id', default=datetime.datetime.now().isoformat())
     parser.add_argument('-p', '--price', default='0')
     parser.add_argument('-q', '--quantity', default='1')
+    parser.add_argument('-Q', '--quote-quantity', dest='quoteQuantity', default='0',
+                        help='size a market order in quote currency instead')
+    parser.add_argument('-m', '--max-slippage', dest='maxSlippage', default='0',
+                        help='protect a market order from trading further from the touch, e.g. 0.01')
+    parser.add_argument('-S', '--stop-price', dest='stopPrice', default='0')
+    parser.add_argument('-D', '--display-quantity', dest='displayQuantity', default='0',
+                        help='show only this much of an iceberg order')
     parser.add_argument('-t', '--type', default='limit', choices=types)
+    parser.add_argument('-f', '--time-in-force', dest='timeInForce', default='gtc', choices=tifs)
+    parser.add_argument('-e', '--expire-time', dest='expireTime',
+                        help='RFC 3339 expire time of GTD orders')
+    parser.add_argument('-o', '--post-only', dest='postOnly', default='none', choices=postOnlys)
+    parser.add_argument('-w', '--owner', default='')
+    parser.add_argument('-P', '--self-trade-prevention', dest='selfTradePrevention',
+                        default='cancel-newest', choices=stps)
+    parser.add_argument('-n', '--simulate', action='store_true',
+                        help='only estimate what the order would execute')
 
     args = parser.parse_args()
     args.type = types.index(args.type)
     args.side = sides.index(args.side)
+    args.timeInForce = tifs.index(args.timeInForce)
+    args.postOnly = postOnlys.index(args.postOnly)
+    args.selfTradePrevention = stps.index(args.selfTradePrevention)
+    if args.quoteQuantity != '0':
+        args.quantity = '0'
+    if args.expireTime is None:
+        del args.expireTime
     return args
 
 
 def main():
     args = parse()
-    resp = requests.post('http://127.0.0.1:7701/orders/', json=args.__dict__)
+    path = 'simulate' if args.simulate else ''
+    del args.simulate
+    resp = requests.post('http://127.0.0.1:7701/orders/' + path, json=args.__dict__)
     print('Status code:')
     print(' ', resp.status_code)
     print('Response:')
@@ -9,6 +9,31 @@
 # Type:
 #   0 - Limit
 #   1 - Market
+#   2 - Stop (market), requires "stopPrice"
+#   3 - Stop limit, requires "stopPrice"
+#
+# Market orders may be sized in quote currency with "quoteQuantity"
+# (instead of "quantity") and protected with "maxSlippage" from the
+# touch, e.g. "0.01" for 1%.
+
+# Time in force:
+#   0 - GTC (good till canceled)
+#   1 - IOC (immediate or cancel)
+#   2 - FOK (fill or kill)
+#   3 - GTD (good till date, requires "expireTime")
+#   4 - DAY (good till the end of the UTC day)
+
+# Post-only (limit and stop limit only):
+#   0 - None
+#   1 - Reject the order if it would take liquidity
+#   2 - Slide the order one tick away from the touch
+
+# Self-trade prevention (applies to orders with an "owner"):
+#   0 - Cancel newest (the incoming order)
+#   1 - Cancel oldest (the resting order)
+#   2 - Cancel both
+#   3 - Decrement both, cancel the smaller one
+#   4 - Allow self-trades
 
 SERVER=127.0.0.1:7701
 
@@ -18,7 +43,11 @@ read -d '' BODY << EOF
     "quantity": "10",
     "price": "1000",
     "id": "something",
-    "type": 0
+    "type": 0,
+    "timeInForce": 0,
+    "postOnly": 0,
+    "owner": "",
+    "selfTradePrevention": 0
 }
 EOF
 
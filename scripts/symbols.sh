@@ -0,0 +1,5 @@
+#!/bin/bash
+
+SERVER=127.0.0.1:7701
+curl $SERVER/symbols/
+echo
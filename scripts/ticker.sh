@@ -0,0 +1,8 @@
+#!/bin/bash
+
+# This script prints the best bid and offer of the given (or default)
+# symbol.
+
+SERVER=127.0.0.1:7701
+curl "$SERVER/ticker?symbol=$1"
+echo
@@ -0,0 +1,13 @@
+#!/bin/bash
+
+# This script prints the depth changes of the given (or default) symbol
+# since the given sequence number, e.g. the one of a book snapshot.
+
+if [ -z "$1" ] ; then
+    echo "usage: $0 <since> [symbol]"
+    exit 1
+fi
+
+SERVER=127.0.0.1:7701
+curl "$SERVER/book/updates?since=$1&symbol=$2"
+echo
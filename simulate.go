@@ -0,0 +1,126 @@
+package orderbook
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// Simulation is what an order would execute if it was submitted right
+// now.  Prices are zero if nothing would execute.
+type Simulation struct {
+	Fills         []ClientLevel   `json:"fills"`         // Quantity filled at each level, best one first.
+	Quantity      decimal.Decimal `json:"quantity"`      // Order quantity, calculated for quote-sized orders.
+	Filled        decimal.Decimal `json:"filled"`        // Total quantity filled.
+	Unfilled      decimal.Decimal `json:"unfilled"`      // Quantity left unmatched.
+	QuoteQuantity decimal.Decimal `json:"quoteQuantity"` // Total value of the fills.
+	AveragePrice  decimal.Decimal `json:"averagePrice"`
+	WorstPrice    decimal.Decimal `json:"worstPrice"`
+	MidPrice      decimal.Decimal `json:"midPrice"` // Zero if either side of the book is empty.
+	Slippage      decimal.Decimal `json:"slippage"` // Of the average price from the mid, positive is worse.
+	Sequence      uint64          `json:"sequence"` // Sequence number of the book simulated against.
+}
+
+// Simulate walks the opposite side of the book the way matching would
+// for the given order, but without executing or storing anything.  Stop
+// orders get simulated as if they were triggered now.  Orders that would
+// get rejected return the same error AddOrder would.  Makers due to
+// expire get skipped, as AddOrder would expire them first.
+func (b *Book) Simulate(order ClientOrder) (Simulation, error) {
+	if order.Symbol == "" {
+		order.Symbol = b.Symbol
+	}
+
+	invalid := b.checkOrder(&order)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+
+	if invalid == nil {
+		invalid = b.checkPlacement(&order, now)
+	}
+
+	if invalid != nil {
+		return Simulation{}, invalid
+	}
+
+	live := b.unexpired(now)
+
+	if err := b.checkPostOnly(&order, live); err != nil {
+		return Simulation{}, err
+	}
+
+	_, op, err := b.matchSides(order.Side)
+	if err != nil {
+		return Simulation{}, err
+	}
+
+	x := NewOrder(order.Handle, order.units.quantity)
+	x.Owner = order.Owner
+	x.SelfTrade = order.SelfTrade
+
+	var fills []Fill
+
+	if IsMarket(order.Type) {
+		crosses := anyLevel
+
+		if limit, limited := b.protectionPrice(order, op, live); limited {
+			crosses = op.within(limit)
+		}
+
+		if order.QuoteQuantity.IsPositive() {
+			x.Quantity = op.quoteQuantity(crosses, order.QuoteQuantity, b.Instrument, live)
+		}
+
+		fills = op.fills(crosses, x, live)
+	} else {
+		fills = op.fills(op.within(order.units.price), x, live)
+	}
+
+	// Fill-or-kill orders get executed either fully or not at all.
+	if order.TimeInForce == TimeInForceFOK && sumFills(fills) < x.Quantity {
+		fills = []Fill{}
+	}
+
+	return b.newSimulation(order.Side, x.Quantity, fills), nil
+}
+
+// newSimulation sums up the given fills of an order.  The caller must
+// hold b.mu.
+func (b *Book) newSimulation(side int, quantity int64, fills []Fill) Simulation {
+	ans := Simulation{
+		Fills:         make([]ClientLevel, 0, len(fills)),
+		Quantity:      b.Instrument.Quantity(quantity),
+		Filled:        b.Instrument.Quantity(sumFills(fills)),
+		Unfilled:      b.Instrument.Quantity(quantity - sumFills(fills)),
+		QuoteQuantity: decimal.Zero,
+		AveragePrice:  decimal.Zero,
+		WorstPrice:    decimal.Zero,
+		MidPrice:      b.bbo().MidPrice,
+		Slippage:      decimal.Zero,
+		Sequence:      b.sequence,
+	}
+
+	for _, fill := range fills {
+		level := ClientLevel{Price: b.Instrument.Price(fill.Price), Quantity: b.Instrument.Quantity(fill.Quantity)}
+		ans.Fills = append(ans.Fills, level)
+		ans.QuoteQuantity = ans.QuoteQuantity.Add(level.Price.Mul(level.Quantity))
+		ans.WorstPrice = level.Price
+	}
+
+	if !ans.Filled.IsPositive() {
+		return ans
+	}
+
+	ans.AveragePrice = ans.QuoteQuantity.Div(ans.Filled)
+
+	if ans.MidPrice.IsPositive() {
+		ans.Slippage = ans.AveragePrice.Sub(ans.MidPrice).Div(ans.MidPrice)
+
+		if side == SideSell {
+			ans.Slippage = ans.Slippage.Neg()
+		}
+	}
+
+	return ans
+}
@@ -0,0 +1,184 @@
+package orderbook_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+func newSimulationBook(t *testing.T) *orderbook.Book {
+	t.Helper()
+
+	b := orderbook.NewBook()
+	iceberg := limitOrder("sell101", orderbook.SideSell, 101, 3)
+	iceberg.DisplayQuantity = decimal.NewFromInt(1)
+
+	submit(t, b, limitOrder("sell100", orderbook.SideSell, 100, 2))
+	submit(t, b, iceberg)
+	submit(t, b, limitOrder("sell102", orderbook.SideSell, 102, 5))
+	submit(t, b, limitOrder("buy99", orderbook.SideBuy, 99, 1))
+
+	return b
+}
+
+func TestBook_Simulate(t *testing.T) {
+	t.Parallel()
+
+	b := newSimulationBook(t)
+	before := b.GetL3Snapshot(10)
+	order := marketOrder("market", orderbook.SideBuy, 4)
+
+	simulation, err := b.Simulate(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The book stays as it was.
+	if after := b.GetL3Snapshot(10); !reflect.DeepEqual(before, after) {
+		t.Errorf("have %v, want %v", after, before)
+	}
+
+	assertDecimal := func(name string, have decimal.Decimal, want string) {
+		t.Helper()
+
+		if !have.Equal(decimal.RequireFromString(want)) {
+			t.Errorf("have %s %v, want %s", name, have, want)
+		}
+	}
+
+	if len(simulation.Fills) != 2 {
+		t.Fatalf("have %d fills, want 2", len(simulation.Fills))
+	}
+
+	assertDecimal("fill", simulation.Fills[0].Quantity, "2")
+	assertDecimal("fill", simulation.Fills[1].Quantity, "2")
+	assertDecimal("filled", simulation.Filled, "4")
+	assertDecimal("unfilled", simulation.Unfilled, "0")
+	assertDecimal("quote quantity", simulation.QuoteQuantity, "402")
+	assertDecimal("average price", simulation.AveragePrice, "100.5")
+	assertDecimal("worst price", simulation.WorstPrice, "101")
+	assertDecimal("mid price", simulation.MidPrice, "99.5")
+	assertDecimal("slippage", simulation.Slippage, decimal.NewFromInt(1).Div(decimal.RequireFromString("99.5")).String())
+
+	// Executing the order gives the same result.
+	if report := submit(t, b, order); !report.QuoteQuantity.Equal(simulation.QuoteQuantity) {
+		t.Errorf("have %v, want %v", report.QuoteQuantity, simulation.QuoteQuantity)
+	}
+}
+
+func TestBook_Simulate_Limit(t *testing.T) {
+	t.Parallel()
+
+	b := newSimulationBook(t)
+
+	simulation, err := b.Simulate(limitOrder("limit", orderbook.SideBuy, 101, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !simulation.Filled.Equal(decimal.NewFromInt(5)) || !simulation.Unfilled.Equal(decimal.NewFromInt(5)) {
+		t.Errorf("have %v filled and %v unfilled, want 5 and 5", simulation.Filled, simulation.Unfilled)
+	}
+
+	fok := limitOrder("fok", orderbook.SideBuy, 101, 10)
+	fok.TimeInForce = orderbook.TimeInForceFOK
+
+	if simulation, err := b.Simulate(fok); err != nil || len(simulation.Fills) != 0 {
+		t.Errorf("have %v, %v, want no fills", simulation.Fills, err)
+	}
+
+	postOnly := limitOrder("post", orderbook.SideBuy, 101, 1)
+	postOnly.PostOnly = orderbook.PostOnlyReject
+
+	if _, err := b.Simulate(postOnly); !errors.Is(err, orderbook.ErrPostOnlyWouldTake) {
+		t.Errorf("have %v, want ErrPostOnlyWouldTake", err)
+	}
+}
+
+func TestBook_Simulate_Expired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2022, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := orderbook.NewBook(orderbook.WithClock(func() time.Time { return now }))
+
+	gtd := limitOrder("gtd", orderbook.SideSell, 100, 1)
+	gtd.TimeInForce = orderbook.TimeInForceGTD
+	gtd.ExpireTime = now.Add(time.Minute)
+
+	submit(t, b, gtd)
+	submit(t, b, limitOrder("sell101", orderbook.SideSell, 101, 1))
+
+	// The GTD order has expired, but nothing has swept it yet.
+	now = now.Add(2 * time.Minute)
+	before := b.GetL3Snapshot(10)
+
+	postOnly := limitOrder("post", orderbook.SideBuy, 100, 1)
+	postOnly.PostOnly = orderbook.PostOnlyReject
+
+	if _, err := b.Simulate(postOnly); err != nil {
+		t.Errorf("have %v, want nil", err)
+	}
+
+	order := marketOrder("market", orderbook.SideBuy, 1)
+
+	simulation, err := b.Simulate(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after := b.GetL3Snapshot(10); !reflect.DeepEqual(before, after) {
+		t.Errorf("have %v, want %v", after, before)
+	}
+
+	if !simulation.Filled.Equal(decimal.NewFromInt(1)) || !simulation.WorstPrice.Equal(decimal.NewFromInt(101)) {
+		t.Errorf("have %v filled at %v, want 1 at 101", simulation.Filled, simulation.WorstPrice)
+	}
+
+	// Executing the order gives the same result.
+	if report := submit(t, b, order); !report.QuoteQuantity.Equal(simulation.QuoteQuantity) {
+		t.Errorf("have %v, want %v", report.QuoteQuantity, simulation.QuoteQuantity)
+	}
+
+	assertStates(t, b, map[string]int{"gtd": orderbook.StateExpired, "sell101": orderbook.StateFilled})
+}
+
+// The maker of the taker's own behind an iceberg gets reached once the
+// iceberg replenishes, just as in matching.
+func TestBook_Simulate_SelfTradeIceberg(t *testing.T) {
+	t.Parallel()
+
+	iceberg := limitOrder("iceberg", orderbook.SideSell, 100, 3)
+	iceberg.DisplayQuantity = decimal.NewFromInt(1)
+	iceberg.Owner = "bob"
+
+	own := limitOrder("own", orderbook.SideSell, 100, 1)
+	own.Owner = "alice"
+
+	b := orderbook.NewBook()
+	submit(t, b, iceberg)
+	submit(t, b, own)
+
+	order := limitOrder("buy", orderbook.SideBuy, 100, 3)
+	order.Owner = "alice"
+	order.SelfTrade = orderbook.SelfTradeCancelNewest
+
+	simulation, err := b.Simulate(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !simulation.Filled.Equal(decimal.NewFromInt(1)) {
+		t.Errorf("have %v filled, want 1", simulation.Filled)
+	}
+
+	report := submit(t, b, order)
+	if !report.Order.ExecutedQuantity.Equal(simulation.Filled) {
+		t.Errorf("have %v executed, want %v", report.Order.ExecutedQuantity, simulation.Filled)
+	}
+
+	assertStates(t, b, map[string]int{"buy": orderbook.StateCanceled})
+}
@@ -0,0 +1,151 @@
+package orderbook
+
+// skipListMaxHeight allows for millions of levels before lookups start
+// to slow down.
+const skipListMaxHeight = 24
+
+type skipNode struct {
+	key   int64 // The best level has the lowest key, see skipList.key.
+	level *Level
+	next  []*skipNode
+}
+
+// skipList keeps the levels in a skip list ordered from the best one.
+// Insertion and removal take O(logN) on average, the best level is the
+// first one and in-order traversal follows the bottom list, so walking
+// the top N levels takes O(N) and allocates nothing.
+type skipList struct {
+	ladderType int
+	head       skipNode
+	height     int
+	nodes      map[int64]*skipNode // Keyed by price.
+	seed       uint64
+}
+
+// NewSkipListBackend creates a backend that keeps the levels in a skip
+// list.  It accepts any price.
+func NewSkipListBackend(ladderType int) LadderBackend {
+	const seed = 0x9e3779b97f4a7c15
+
+	return &skipList{
+		ladderType: ladderType,
+		head:       skipNode{key: 0, level: nil, next: make([]*skipNode, skipListMaxHeight)},
+		height:     1,
+		nodes:      make(map[int64]*skipNode),
+		seed:       seed,
+	}
+}
+
+// key turns the price of a level into its key in the list, so asks go
+// from the lowest price and bids from the highest one.
+func (s *skipList) key(price int64) int64 {
+	switch s.ladderType {
+	case Ask:
+		return price
+	case Bid:
+		return -price
+	default:
+		panic("illegal type")
+	}
+}
+
+// randomHeight returns the height of a new node: 1 with probability
+// 3/4, 2 with probability 3/16 and so on.
+func (s *skipList) randomHeight() int {
+	// Xorshift is plenty for balancing.
+	s.seed ^= s.seed << 13
+	s.seed ^= s.seed >> 7
+	s.seed ^= s.seed << 17
+
+	height := 1
+	for x := s.seed; height < skipListMaxHeight && x&3 == 0; x >>= 2 {
+		height++
+	}
+
+	return height
+}
+
+// path returns the last node before the given key on each list.
+func (s *skipList) path(key int64) [skipListMaxHeight]*skipNode {
+	var ans [skipListMaxHeight]*skipNode
+
+	x := &s.head
+	for i := s.height - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+
+		ans[i] = x
+	}
+
+	return ans
+}
+
+func (s *skipList) Len() int {
+	return len(s.nodes)
+}
+
+func (s *skipList) Best() *Level {
+	if first := s.head.next[0]; first != nil {
+		return first.level
+	}
+
+	return nil
+}
+
+func (s *skipList) Get(price int64) (*Level, bool) {
+	if node, ok := s.nodes[price]; ok {
+		return node.level, true
+	}
+
+	return nil, false
+}
+
+func (s *skipList) Accepts(_ int64) bool {
+	return true
+}
+
+func (s *skipList) Insert(level *Level) {
+	key := s.key(level.Price)
+	path := s.path(key)
+
+	height := s.randomHeight()
+	for ; s.height < height; s.height++ {
+		path[s.height] = &s.head
+	}
+
+	node := &skipNode{key: key, level: level, next: make([]*skipNode, height)}
+	for i := 0; i < height; i++ {
+		node.next[i] = path[i].next[i]
+		path[i].next[i] = node
+	}
+
+	s.nodes[level.Price] = node
+}
+
+func (s *skipList) Remove(level *Level) {
+	path := s.path(s.key(level.Price))
+
+	node := path[0].next[0]
+	if node == nil || node.level != level {
+		panic("illegal state")
+	}
+
+	for i := range node.next {
+		path[i].next[i] = node.next[i]
+	}
+
+	for s.height > 1 && s.head.next[s.height-1] == nil {
+		s.height--
+	}
+
+	delete(s.nodes, level.Price)
+}
+
+func (s *skipList) Walk(f func(level *Level) bool) {
+	for x := s.head.next[0]; x != nil; x = x.next[0] {
+		if !f(x.level) {
+			return
+		}
+	}
+}
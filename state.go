@@ -0,0 +1,107 @@
+package orderbook
+
+import (
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+// Reasons recorded in ClientOrder.Reason when an order gets closed
+// without being (fully) filled.
+const (
+	ReasonCanceled          = "canceled by client"
+	ReasonExpired           = "expired"
+	ReasonFillOrKill        = "fill-or-kill order cannot be fully filled"
+	ReasonImmediateOrCancel = "immediate-or-cancel order not fully filled"
+	ReasonMarket            = "market order not fully executed"
+	ReasonOverflow          = "total quantity of the book side is too large"
+	ReasonSelfTrade         = "self-trade prevented"
+	ReasonSlippage          = "market order reached its protection price"
+)
+
+// transitions lists the states an order may move to from each state.
+// Filled, canceled, expired and rejected orders are final.
+//
+//	Initial --> Placed --> PartiallyFilled --> Filled
+//	   |          |               |
+//	   |          +---------------+--> Canceled, Expired
+//	   +--> Rejected, Canceled, PartiallyFilled, Filled
+//	   +--> PendingTrigger --> Placed, PartiallyFilled, Filled, Canceled, Expired
+var transitions = map[int][]int{ //nolint:gochecknoglobals
+	StateInitial: {
+		StatePlaced, StatePartiallyFilled, StateFilled, StateCanceled, StateRejected, StatePendingTrigger,
+	},
+	StatePendingTrigger:  {StatePlaced, StatePartiallyFilled, StateFilled, StateCanceled, StateExpired},
+	StatePlaced:          {StatePartiallyFilled, StateFilled, StateCanceled, StateExpired},
+	StatePartiallyFilled: {StatePartiallyFilled, StateFilled, StateCanceled, StateExpired},
+	StateFilled:          {},
+	StateCanceled:        {},
+	StateExpired:         {},
+	StateRejected:        {},
+}
+
+// CanTransition reports whether an order may move from one state to
+// another.
+func CanTransition(from, to int) bool {
+	for _, x := range transitions[from] {
+		if x == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsFinal reports whether an order in the given state is done, i.e.
+// cannot be matched, canceled or modified anymore.
+func IsFinal(state int) bool {
+	return len(transitions[state]) == 0
+}
+
+// transition moves the order to the given state and records the reason
+// for it.  It also keeps the order's leaves quantity up to date.
+func (o *ClientOrder) transition(state int, reason string) error {
+	if !CanTransition(o.State, state) {
+		return fmt.Errorf("%w: %d -> %d", ErrInvalidTransition, o.State, state)
+	}
+
+	o.State = state
+	o.Reason = reason
+
+	if IsFinal(state) {
+		o.LeavesQuantity = decimal.Zero
+	} else {
+		o.LeavesQuantity = o.OriginalQuantity.Sub(o.ExecutedQuantity).Sub(o.PreventedQuantity)
+	}
+
+	return nil
+}
+
+// fill executes the given quantity of the order, which is the given
+// number of lots.
+func (o *ClientOrder) fill(quantity decimal.Decimal, lots int64) error {
+	o.ExecutedQuantity = o.ExecutedQuantity.Add(quantity)
+	o.units.executed += lots
+
+	if o.units.leaves() <= 0 {
+		return o.transition(StateFilled, "")
+	}
+
+	return o.transition(StatePartiallyFilled, "")
+}
+
+// decrement takes the given quantity off the order without executing
+// it, as self-trade prevention does.  The quantity is the given number
+// of lots.  The order gets canceled when nothing is left of it.
+func (o *ClientOrder) decrement(quantity decimal.Decimal, lots int64) error {
+	o.PreventedQuantity = o.PreventedQuantity.Add(quantity)
+	o.units.prevented += lots
+
+	if o.units.leaves() <= 0 {
+		return o.transition(StateCanceled, ReasonSelfTrade)
+	}
+
+	o.LeavesQuantity = o.OriginalQuantity.Sub(o.ExecutedQuantity).Sub(o.PreventedQuantity)
+
+	return nil
+}
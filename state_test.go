@@ -0,0 +1,41 @@
+package orderbook_test
+
+import (
+	"testing"
+
+	"github.com/ydm/orderbook"
+)
+
+func TestCanTransition(t *testing.T) {
+	t.Parallel()
+
+	check := func(from, to int, want bool) {
+		t.Helper()
+
+		if have := orderbook.CanTransition(from, to); have != want {
+			t.Errorf("%d -> %d: have %t, want %t", from, to, have, want)
+		}
+	}
+
+	check(orderbook.StateInitial, orderbook.StatePlaced, true)
+	check(orderbook.StateInitial, orderbook.StateRejected, true)
+	check(orderbook.StateInitial, orderbook.StateExpired, false)
+	check(orderbook.StatePlaced, orderbook.StatePartiallyFilled, true)
+	check(orderbook.StatePlaced, orderbook.StateRejected, false)
+	check(orderbook.StatePartiallyFilled, orderbook.StatePartiallyFilled, true)
+	check(orderbook.StatePartiallyFilled, orderbook.StateCanceled, true)
+	check(orderbook.StatePartiallyFilled, orderbook.StatePlaced, false)
+
+	for _, state := range []int{
+		orderbook.StateFilled,
+		orderbook.StateCanceled,
+		orderbook.StateExpired,
+		orderbook.StateRejected,
+	} {
+		if !orderbook.IsFinal(state) {
+			t.Errorf("state %d should be final", state)
+		}
+
+		check(state, orderbook.StateCanceled, false)
+	}
+}
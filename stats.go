@@ -0,0 +1,139 @@
+package orderbook
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// DefaultStatsWindow is how far back the rolling statistics of a book
+// reach by default.
+const DefaultStatsWindow = 24 * time.Hour
+
+// statsBucket is the resolution of the rolling window.  Trades leave the
+// window a bucket at a time.
+const statsBucket = time.Minute
+
+// Stats are rolling statistics of the trades within a window of time,
+// see Book.Stats.  Without trades in the window, only the last price may
+// be set.
+type Stats struct {
+	From               time.Time       `json:"from"` // Start of the window.
+	To                 time.Time       `json:"to"`
+	LastPrice          decimal.Decimal `json:"lastPrice"` // Of the last trade ever.
+	OpenPrice          decimal.Decimal `json:"openPrice"` // Of the first trade within the window.
+	HighPrice          decimal.Decimal `json:"highPrice"`
+	LowPrice           decimal.Decimal `json:"lowPrice"`
+	PriceChange        decimal.Decimal `json:"priceChange"`        // From the open to the last price.
+	PriceChangePercent decimal.Decimal `json:"priceChangePercent"` // Of the open price.
+	Volume             decimal.Decimal `json:"volume"`
+	QuoteVolume        decimal.Decimal `json:"quoteVolume"` // Total value of the trades.
+	VWAP               decimal.Decimal `json:"vwap"`        // Volume-weighted average price.
+	Trades             int             `json:"trades"`
+}
+
+// rollingStats keeps the trades of the window summed up in buckets.
+// Buckets get pruned by time, so their number is not limited.
+type rollingStats struct {
+	window    time.Duration
+	buckets   candleSeries
+	lastPrice int64 // In ticks.
+}
+
+func newRollingStats(window time.Duration) rollingStats {
+	return rollingStats{
+		window:    window,
+		buckets:   newCandleSeries(statsBucket, 0),
+		lastPrice: 0,
+	}
+}
+
+// WithStatsWindow sets how far back the rolling statistics of the book
+// reach.  It must be positive.
+func WithStatsWindow(window time.Duration) BookOption {
+	return func(b *Book) {
+		b.stats.window = window
+	}
+}
+
+func (r *rollingStats) add(trade Trade) {
+	r.buckets.add(trade)
+	r.lastPrice = trade.ticks
+	r.prune(trade.Time)
+}
+
+// prune drops the buckets that ended before the window.
+func (r *rollingStats) prune(now time.Time) {
+	start := now.Add(-r.window)
+	buckets := r.buckets.candles
+
+	i := 0
+	for i < len(buckets) && !buckets[i].time.Add(statsBucket).After(start) {
+		i++
+	}
+
+	r.buckets.candles = buckets[i:]
+}
+
+// Stats returns the statistics of the trades within the rolling window
+// ending now.  Trades of the bucket the window starts in are included.
+func (b *Book) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	r := &b.stats
+	r.prune(now)
+
+	ans := Stats{
+		From:               now.Add(-r.window),
+		To:                 now,
+		LastPrice:          b.Instrument.Price(r.lastPrice),
+		OpenPrice:          decimal.Zero,
+		HighPrice:          decimal.Zero,
+		LowPrice:           decimal.Zero,
+		PriceChange:        decimal.Zero,
+		PriceChangePercent: decimal.Zero,
+		Volume:             decimal.Zero,
+		QuoteVolume:        decimal.Zero,
+		VWAP:               decimal.Zero,
+		Trades:             0,
+	}
+
+	buckets := r.buckets.candles
+	if len(buckets) == 0 {
+		return ans
+	}
+
+	// Sum up the buckets in ticks and lots, like candle.add does.
+	total := buckets[0]
+
+	for _, bucket := range buckets[1:] {
+		if bucket.high > total.high {
+			total.high = bucket.high
+		}
+
+		if bucket.low < total.low {
+			total.low = bucket.low
+		}
+
+		total.volume.addAll(bucket.volume)
+		total.quoteVolume.addAll(bucket.quoteVolume)
+		total.trades += bucket.trades
+	}
+
+	ans.OpenPrice = b.Instrument.Price(total.open)
+	ans.HighPrice = b.Instrument.Price(total.high)
+	ans.LowPrice = b.Instrument.Price(total.low)
+	ans.Volume, ans.QuoteVolume = exportVolumes(b.Instrument, total.volume, total.quoteVolume)
+	ans.Trades = total.trades
+
+	ans.PriceChange = ans.LastPrice.Sub(ans.OpenPrice)
+	ans.VWAP = ans.QuoteVolume.Div(ans.Volume)
+
+	if ans.OpenPrice.IsPositive() {
+		ans.PriceChangePercent = ans.PriceChange.Div(ans.OpenPrice).Mul(decimal.NewFromInt(100))
+	}
+
+	return ans
+}
@@ -0,0 +1,72 @@
+package orderbook_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+func assertStats(t *testing.T, stats orderbook.Stats, open, high, low, last, volume string, trades int) {
+	t.Helper()
+
+	have := []decimal.Decimal{stats.OpenPrice, stats.HighPrice, stats.LowPrice, stats.LastPrice, stats.Volume}
+
+	for i, want := range []string{open, high, low, last, volume} {
+		if !have[i].Equal(decimal.RequireFromString(want)) {
+			t.Errorf("have %v, want %s at %d", have, want, i)
+		}
+	}
+
+	if stats.Trades != trades {
+		t.Errorf("have %d trades, want %d", stats.Trades, trades)
+	}
+}
+
+func TestBook_Stats(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	b := orderbook.NewBook(orderbook.WithClock(func() time.Time { return now }))
+
+	submit(t, b, limitOrder("sell100", orderbook.SideSell, 100, 10))
+	submit(t, b, limitOrder("sell110", orderbook.SideSell, 110, 10))
+	submit(t, b, marketOrder("buy1", orderbook.SideBuy, 2))
+
+	now = start.Add(time.Hour)
+	submit(t, b, marketOrder("buy2", orderbook.SideBuy, 9))
+
+	now = start.Add(23 * time.Hour)
+	submit(t, b, limitOrder("buy95", orderbook.SideBuy, 95, 1))
+	submit(t, b, marketOrder("sell", orderbook.SideSell, 1))
+
+	now = start.Add(23*time.Hour + 30*time.Minute)
+	stats := b.Stats()
+	assertStats(t, stats, "100", "110", "95", "95", "12", 4)
+
+	expected := []struct {
+		name       string
+		have, want decimal.Decimal
+	}{
+		{"price change", stats.PriceChange, decimal.NewFromInt(-5)},
+		{"price change percent", stats.PriceChangePercent, decimal.NewFromInt(-5)},
+		{"quote volume", stats.QuoteVolume, decimal.NewFromInt(1205)},
+		{"VWAP", stats.VWAP, decimal.NewFromInt(1205).Div(decimal.NewFromInt(12))},
+	}
+
+	for _, x := range expected {
+		if !x.have.Equal(x.want) {
+			t.Errorf("have %s %v, want %v", x.name, x.have, x.want)
+		}
+	}
+
+	// The first trade leaves the window.
+	now = start.Add(24*time.Hour + 30*time.Minute)
+	assertStats(t, b.Stats(), "100", "110", "95", "95", "10", 3)
+
+	// Only the last price stays once all trades leave the window.
+	now = start.Add(48 * time.Hour)
+	assertStats(t, b.Stats(), "0", "0", "0", "95", "0", 0)
+}
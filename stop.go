@@ -0,0 +1,108 @@
+package orderbook
+
+import (
+	"time"
+)
+
+// stopsOf returns the trigger ladder for stop orders of the given side.
+// Buy stops trigger when the price rises to their stop price, so the
+// lowest one comes first, just like in an ask ladder.  Sell stops are the
+// other way around.
+func (b *Book) stopsOf(side int) *Ladder {
+	switch side {
+	case SideBuy:
+		return &b.buyStops
+	case SideSell:
+		return &b.sellStops
+	default:
+		panic("illegal side")
+	}
+}
+
+// ladderOf returns the ladder holding the given order (if it is still
+// open) along with the price it is kept at, in ticks.
+func (b *Book) ladderOf(order ClientOrder) (*Ladder, int64, error) {
+	if order.State == StatePendingTrigger {
+		return b.stopsOf(order.Side), order.units.stopPrice, nil
+	}
+
+	my, _, err := b.matchSides(order.Side)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return my, order.units.price, nil
+}
+
+// park puts the given stop order into the trigger ladder, where it waits
+// for the last price to reach its stop price.  The caller must hold b.mu.
+func (b *Book) park(order *ClientOrder, now time.Time) {
+	if err := order.transition(StatePendingTrigger, ""); err != nil {
+		panic(err)
+	}
+
+	b.stopsOf(order.Side).AddOrder(order.units.stopPrice, NewOrder(order.Handle, order.units.quantity))
+
+	if !order.ExpireTime.IsZero() {
+		b.scheduleExpiry(order.Handle, order.ExpireTime)
+	}
+
+	b.store(*order, nil, now)
+}
+
+// trigger releases the stop orders whose stop price was reached by the
+// last trade into the matching path, one at a time.  Each triggered order
+// may trade and move the last price further, triggering even more stop
+// orders.  Returns the trades and prevented self-trades of all triggered
+// orders.  The caller must hold b.mu.
+func (b *Book) trigger(now time.Time) ([]Trade, []PreventedMatch) {
+	trades := make([]Trade, 0)
+	prevented := make([]PreventedMatch, 0)
+
+	for len(b.trades) > 0 {
+		last := b.trades[len(b.trades)-1].ticks
+
+		var stops *Ladder
+
+		switch {
+		case b.buyStops.Crosses(last):
+			stops = &b.buyStops
+		case b.sellStops.Crosses(last):
+			stops = &b.sellStops
+		default:
+			return trades, prevented
+		}
+
+		// Stops at the same price trigger in the order they were
+		// submitted.
+		level := stops.Best()
+		handle := level.Orders.Peek().Handle
+
+		if !stops.RemoveOrder(level.Price, handle) {
+			panic("illegal state")
+		}
+
+		b.databaseMutex.Lock()
+		order := b.database[handle]
+		b.databaseMutex.Unlock()
+
+		b.emitOrder(EventOrderTriggered, order, now)
+
+		// Triggered post-only orders may still not take liquidity.
+		if err := b.checkPostOnly(&order, nil); err != nil {
+			if err := order.transition(StateCanceled, err.Error()); err != nil {
+				panic(err)
+			}
+
+			b.store(order, nil, now)
+
+			continue
+		}
+
+		xs, ys := b.match(&order, now)
+		trades = append(trades, xs...)
+		prevented = append(prevented, ys...)
+	}
+
+	return trades, prevented
+}
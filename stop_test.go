@@ -0,0 +1,142 @@
+package orderbook_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/ydm/orderbook"
+)
+
+func stopOrder(id string, side int, stopPrice, price, quantity int64) orderbook.ClientOrder {
+	order := limitOrder(id, side, price, quantity)
+	order.StopPrice = decimal.NewFromInt(stopPrice)
+
+	if price == 0 {
+		order.Type = orderbook.TypeStop
+	} else {
+		order.Type = orderbook.TypeStopLimit
+	}
+
+	return order
+}
+
+// A stop order waits outside of the book until a trade reaches its stop
+// price and then executes under the same ID.
+func TestBook_AddOrder_Stop(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	submit(t, b, limitOrder("ask100", orderbook.SideSell, 100, 1))
+	submit(t, b, limitOrder("ask101", orderbook.SideSell, 101, 5))
+
+	report := submit(t, b, stopOrder("stop", orderbook.SideBuy, 100, 0, 2))
+	if report.Order.State != orderbook.StatePendingTrigger || len(report.Trades) != 0 {
+		t.Errorf("unexpected report %v", report)
+	}
+
+	assertCountLevels(t, b, 2, 0)
+
+	// This trade at 100 triggers the stop, which then buys at 101.
+	submit(t, b, limitOrder("buy", orderbook.SideBuy, 100, 1))
+
+	assertLevels(t, &b.Asks, pq{"101", "3"})
+	assertExecutedQuantities(t, b, iq{"stop", "2"}, iq{"ask101", "2"})
+	assertStates(t, b, map[string]int{"stop": orderbook.StateFilled})
+
+	trades := b.GetTrades(0)
+	if last := trades[len(trades)-1]; last.TakerID != "stop" || !last.Price.Equal(decimal.NewFromInt(101)) {
+		t.Errorf("unexpected trade %v", last)
+	}
+}
+
+// One triggered stop order may trigger another.
+func TestBook_AddOrder_StopCascade(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	submit(t, b, limitOrder("bid99", orderbook.SideBuy, 99, 1))
+	submit(t, b, limitOrder("bid98", orderbook.SideBuy, 98, 1))
+	submit(t, b, limitOrder("bid97", orderbook.SideBuy, 97, 1))
+	submit(t, b, stopOrder("stop99", orderbook.SideSell, 99, 0, 1))
+	submit(t, b, stopOrder("stop98", orderbook.SideSell, 98, 97, 2))
+	submit(t, b, stopOrder("stop90", orderbook.SideSell, 90, 0, 1))
+
+	// Selling at 99 triggers stop99, which sells at 98 and triggers stop98,
+	// which takes the bid at 97 and rests the remaining quantity.
+	report := submit(t, b, orderbook.ClientOrder{
+		Side:             orderbook.SideSell,
+		OriginalQuantity: decimal.NewFromInt(1),
+		ExecutedQuantity: decimal.Zero,
+		Price:            decimal.Zero,
+		ID:               "market",
+		Type:             orderbook.TypeMarket,
+	})
+
+	// Only the taker's own trades get reported.
+	if len(report.Trades) != 1 {
+		t.Errorf("have %d trades, want 1", len(report.Trades))
+	}
+
+	assertCountLevels(t, b, 1, 0)
+	assertLevels(t, &b.Asks, pq{"97", "1"})
+	assertStates(t, b, map[string]int{
+		"stop99": orderbook.StateFilled,
+		"stop98": orderbook.StatePartiallyFilled,
+		"stop90": orderbook.StatePendingTrigger,
+	})
+
+	// Pending and triggered stop orders can both be canceled.
+	if err := b.CancelOrder("stop90"); err != nil {
+		t.Error(err)
+	}
+
+	if err := b.CancelOrder("stop98"); err != nil {
+		t.Error(err)
+	}
+
+	assertCountLevels(t, b, 0, 0)
+
+	// The last trade is now at 97, so a sell stop at 97 or above triggers
+	// immediately.
+	submit(t, b, limitOrder("bid95", orderbook.SideBuy, 95, 1))
+
+	report, err := b.AddOrder(stopOrder("stop97", orderbook.SideSell, 97, 0, 1))
+	if err != nil {
+		t.Error(err)
+	}
+
+	if report.Order.State != orderbook.StateFilled || len(report.Trades) != 1 {
+		t.Errorf("unexpected report %v", report)
+	}
+}
+
+// Triggered stop-limit orders rest in the book and can be amended just
+// like limit orders, pending ones cannot.
+func TestBook_AmendOrder_StopLimit(t *testing.T) {
+	t.Parallel()
+
+	b := orderbook.NewBook()
+	submit(t, b, limitOrder("ask100", orderbook.SideSell, 100, 1))
+	submit(t, b, stopOrder("stop", orderbook.SideBuy, 100, 99, 2))
+
+	_, err := b.AmendOrder("stop", decimal.NewFromInt(98), decimal.Zero)
+	if !errors.Is(err, orderbook.ErrCannotAmendOrder) {
+		t.Errorf("have %v, want ErrCannotAmendOrder", err)
+	}
+
+	// This trade at 100 triggers the stop, which rests at 99.
+	submit(t, b, limitOrder("buy", orderbook.SideBuy, 100, 1))
+	assertStates(t, b, map[string]int{"stop": orderbook.StatePlaced})
+
+	report, err := b.AmendOrder("stop", decimal.NewFromInt(98), decimal.NewFromInt(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Order.State != orderbook.StatePlaced || !report.Order.Price.Equal(decimal.NewFromInt(98)) {
+		t.Errorf("unexpected report %v", report)
+	}
+
+	assertLevels(t, &b.Bids, pq{"98", "3"})
+}
@@ -0,0 +1,143 @@
+package orderbook
+
+import (
+	"github.com/shopspring/decimal"
+)
+
+// MaxTicks is the most price levels a tick array backend may cover.
+const MaxTicks = 1 << 24
+
+// tickArray keeps the levels in a dense array with a slot for each tick
+// between a min and a max price.  Insertion, removal and lookups take
+// O(1), the best level is tracked and in-order traversal scans the slots
+// from it, so it suits instruments trading within a tight price range.
+type tickArray struct {
+	ladderType int
+	tickSize   decimal.Decimal // Must be the one of the book, see NewBook.
+	min        int64           // Min price, in ticks.
+	levels     []*Level
+	count      int
+	best       int // Slot of the best level, if there are any.
+}
+
+// TickBackend returns a factory of backends that keep the levels in
+// dense arrays, one slot per tick from minPrice to maxPrice.  The tick
+// size must be the one of the book's instrument, NewBook panics
+// otherwise.  Orders priced outside
+// get rejected with ErrPriceOutOfRange.  It panics if the range is
+// empty, not a whole number of ticks or over MaxTicks long.
+func TickBackend(minPrice, maxPrice, tickSize decimal.Decimal) LadderBackendFactory {
+	if !tickSize.IsPositive() || minPrice.IsNegative() || maxPrice.LessThan(minPrice) ||
+		!minPrice.Mod(tickSize).IsZero() || !maxPrice.Mod(tickSize).IsZero() {
+		panic("invalid tick range")
+	}
+
+	first := minPrice.Div(tickSize).IntPart()
+	n := maxPrice.Div(tickSize).IntPart() - first + 1
+
+	if n > MaxTicks {
+		panic("invalid tick range")
+	}
+
+	return func(ladderType int) LadderBackend {
+		return &tickArray{
+			ladderType: ladderType,
+			tickSize:   tickSize,
+			min:        first,
+			levels:     make([]*Level, n),
+			count:      0,
+			best:       0,
+		}
+	}
+}
+
+// slot returns the slot of the given price.
+func (a *tickArray) slot(price int64) (int, bool) {
+	offset := price - a.min
+	if offset < 0 || offset >= int64(len(a.levels)) {
+		return 0, false
+	}
+
+	return int(offset), true
+}
+
+// step is the direction from better to worse slots.
+func (a *tickArray) step() int {
+	switch a.ladderType {
+	case Ask:
+		return 1
+	case Bid:
+		return -1
+	default:
+		panic("illegal type")
+	}
+}
+
+func (a *tickArray) Len() int {
+	return a.count
+}
+
+func (a *tickArray) Best() *Level {
+	if a.count == 0 {
+		return nil
+	}
+
+	return a.levels[a.best]
+}
+
+func (a *tickArray) Get(price int64) (*Level, bool) {
+	if i, ok := a.slot(price); ok && a.levels[i] != nil {
+		return a.levels[i], true
+	}
+
+	return nil, false
+}
+
+func (a *tickArray) Accepts(price int64) bool {
+	_, ok := a.slot(price)
+
+	return ok
+}
+
+func (a *tickArray) Insert(level *Level) {
+	i, ok := a.slot(level.Price)
+	if !ok || a.levels[i] != nil {
+		panic("illegal state")
+	}
+
+	a.levels[i] = level
+	a.count++
+
+	if a.count == 1 || (i-a.best)*a.step() < 0 {
+		a.best = i
+	}
+}
+
+func (a *tickArray) Remove(level *Level) {
+	i, ok := a.slot(level.Price)
+	if !ok || a.levels[i] != level {
+		panic("illegal state")
+	}
+
+	a.levels[i] = nil
+	a.count--
+
+	// The next best level is further down the array.
+	if a.count > 0 && i == a.best {
+		for a.levels[a.best] == nil {
+			a.best += a.step()
+		}
+	}
+}
+
+func (a *tickArray) Walk(f func(level *Level) bool) {
+	for i, left := a.best, a.count; left > 0; i += a.step() {
+		if level := a.levels[i]; level != nil {
+			if !f(level) {
+				return
+			}
+
+			left--
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package orderbook
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// MaxTrades is how many of the most recent trades a book keeps, older
+// ones get dropped.
+const MaxTrades = 10000
+
+// Trade is a single execution between a taker (the incoming order) and a
+// maker (an order resting in the book).
+type Trade struct {
+	ID          int64           `json:"id"`
+	Symbol      string          `json:"symbol"`
+	TakerID     string          `json:"takerId"`
+	MakerID     string          `json:"makerId"`
+	TakerHandle uint64          `json:"takerHandle"`
+	MakerHandle uint64          `json:"makerHandle"`
+	Price       decimal.Decimal `json:"price"`    // Maker's price.
+	Quantity    decimal.Decimal `json:"quantity"` // Executed quantity.
+	Side        int             `json:"side"`     // Side of the taker (aggressor).
+	Sequence    uint64          `json:"sequence"` // Book sequence number.
+	Time        time.Time       `json:"time"`
+
+	ticks int64 // Price, in ticks.
+	lots  int64 // Quantity, in lots.
+}
+
+func (t Trade) String() string {
+	return fmt.Sprintf("[Trade ID=%d Taker=%s Maker=%s Price=%v Quantity=%v]",
+		t.ID, t.TakerID, t.MakerID, t.Price, t.Quantity)
+}
+
+// PreventedMatch is a match between two orders of the same owner that
+// self-trade prevention did not let execute.
+type PreventedMatch struct {
+	Symbol      string          `json:"symbol"`
+	TakerID     string          `json:"takerId"`
+	MakerID     string          `json:"makerId"`
+	TakerHandle uint64          `json:"takerHandle"`
+	MakerHandle uint64          `json:"makerHandle"`
+	Owner       string          `json:"owner"`
+	Price       decimal.Decimal `json:"price"`    // Maker's price.
+	Quantity    decimal.Decimal `json:"quantity"` // Quantity that would have been executed.
+	Mode        int             `json:"mode"`     // Taker's self-trade prevention mode.
+	Sequence    uint64          `json:"sequence"` // Book sequence number.
+	Time        time.Time       `json:"time"`
+
+	lots int64 // Quantity, in lots.
+}
+
+// Report describes the outcome of submitting an order: its resulting
+// state, the trades it took part in as a taker and the matches
+// self-trade prevention stopped.  Partially executed orders, e.g. market
+// orders that ran out of liquidity, report what they did get along with
+// an error.
+type Report struct {
+	Order         ClientOrder      `json:"order"`
+	Trades        []Trade          `json:"trades"`
+	Prevented     []PreventedMatch `json:"prevented"`
+	QuoteQuantity decimal.Decimal  `json:"quoteQuantity"` // Total value of the trades.
+}
+
+func newReport(order ClientOrder, trades []Trade, prevented []PreventedMatch) Report {
+	quote := decimal.Zero
+
+	// Starting from the first trade spares rescaling zero.
+	for i, trade := range trades {
+		if i == 0 {
+			quote = trade.Price.Mul(trade.Quantity)
+		} else {
+			quote = quote.Add(trade.Price.Mul(trade.Quantity))
+		}
+	}
+
+	return Report{Order: order, Trades: trades, Prevented: prevented, QuoteQuantity: quote}
+}